@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The Teletype Model 33 ASR runs at 10 characters per second
+const ASR33_charTime = 100 * time.Millisecond
+
+// Characters per line, anything printed past the last column is printed on top
+// of it
+const ASR33_columns = 72
+
+// Teletype personalities by the names used on the command line
+var ttyPersonalities = map[string]int{
+	"modern": TT_MODERN,
+	"asr33":  TT_ASR33,
+}
+
+func LookupPersonality(name string) (int, error) {
+	p, ok := ttyPersonalities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown teletype personality %q (modern, asr33)", name)
+	}
+	return p, nil
+}
+
+// Turns a key typed on the host into what a Model 33 sends: there is no lower
+// case, Return sends CR and every character has mark parity (bit 7 set).
+func asr33Key(c byte) byte {
+	c &= 0o177
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	if c == '\n' {
+		c = '\r'
+	}
+	return c | 0o200
+}
+
+// Prints a character the way a Model 33 would. Parity is ignored and lower
+// case codes print as upper case. CR only returns the carriage and LF only
+// advances the paper, so the next line starts in the same column. Past the
+// end of the line characters pile up in the last column. BEL rings the bell,
+// the other control characters don't print.
+func (tt *TeleTypeDevice) asr33Print(c byte) {
+	p := tt.Printer
+	c &= 0o177
+	switch {
+	case c == '\r':
+		p.WriteByte('\r')
+		tt.col = 0
+	case c == '\n':
+		// The host terminal may return the carriage as well, move it back
+		p.WriteByte('\n')
+		if tt.col > 0 {
+			p.WriteByte('\r')
+			for i := 0; i < tt.col; i++ {
+				p.WriteByte(' ')
+			}
+		}
+	case c == 0o007:
+		p.WriteByte(c)
+	case c < ' ' || c == 0o177:
+		// Not printed
+	default:
+		if c >= 'a' {
+			c -= 'a' - 'A'
+		}
+		if tt.col >= ASR33_columns {
+			p.WriteByte('\b')
+		} else {
+			tt.col++
+		}
+		p.WriteByte(c)
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CR8-E card reader device codes
+const (
+	CR_DEV_DATA   = 0o63 // Column data
+	CR_DEV_STATUS = 0o67 // Card feed and status
+)
+
+// CR8-E function codes on device code 63
+const (
+	RCSF = 0o1 // Skip on data ready
+	RCRA = 0o2 // Read the column in compressed alphanumeric code, clear data ready
+	RCRB = 0o4 // Read the column in binary, clear data ready
+)
+
+// CR8-E function codes on device code 67
+const (
+	RCSD = 0o1 // Skip on card done
+	RCSE = 0o2 // Feed a card and skip if the reader was ready
+	RCRD = 0o4 // Clear card done
+	RCSI = 0o5 // Skip on data ready or card done
+	RCRS = 0o7 // Read the status into AC
+)
+
+// Status read by RCRS
+const (
+	CR_ST_HOPPER = 0o4000 // Hopper empty
+	CR_ST_EOD    = 0o2000 // End of deck, the hopper is empty and the last card read
+	CR_ST_BUSY   = 0o1000 // Reading a card
+	CR_ST_NODECK = 0o0400 // No deck loaded
+)
+
+// The reader reads 300 cards a minute, the columns passing the read station
+// in the first 160ms of each card
+const (
+	CR_COLUMNS    = 80
+	CR_columnTime = 2 * time.Millisecond
+	CR_cardTime   = time.Minute / 300
+)
+
+// Card rows as bits of a column, row 12 in bit 0 to row 9 in bit 11
+const (
+	CR_ROW12 = 0o4000
+	CR_ROW11 = 0o2000
+	CR_ROW0  = 0o1000
+)
+
+// Returns the bit of row 1 to 9
+func crRow(n int) uint16 {
+	return 1 << (9 - n)
+}
+
+// CardReader is a CR8-E reading a deck file. A deck is either text, each line
+// a card punched in the DEC 029 code, or, if its name ends in .col, column
+// images of 80 16-bit little endian words a card. RCSE feeds a card when the
+// reader is ready, each column then setting data ready in turn and replacing
+// the one before whether it was read or not, and card done is set once the
+// card has gone through. The reader interrupts on data ready and card done.
+type CardReader struct {
+	Device
+
+	DataReady bool
+	CardDone  bool
+
+	cards [][CR_COLUMNS]uint16
+	next  int // Next card in the hopper
+	img   *diskImage
+
+	column uint16 // Column at the read station
+	busy   bool
+
+	mk   *MK12
+	dev  uint16
+	data uint16 // Read into AC
+	gen  int    // Bumped to drop the columns of a card when the deck changes
+}
+
+func NewCardReader() Storage {
+	return &CardReader{}
+}
+
+func init() {
+	storageControllers["cr"] = NewCardReader
+}
+
+func (cr *CardReader) DriveName() string {
+	return "cr"
+}
+
+func (cr *CardReader) Drives() int {
+	return 1
+}
+
+// Attaching a deck puts it in the hopper
+func (cr *CardReader) Attach(drive int, path string, readOnly bool) error {
+	img, err := openImage(path, true)
+	if err != nil {
+		return err
+	}
+	info, err := img.f.Stat()
+	if err != nil {
+		img.Close()
+		return err
+	}
+	b := make([]byte, info.Size())
+	if err := img.ReadBytes(0, b); err != nil {
+		img.Close()
+		return err
+	}
+	var cards [][CR_COLUMNS]uint16
+	if strings.HasSuffix(strings.ToLower(path), ".col") {
+		cards, err = columnDeck(b)
+	} else {
+		cards, err = textDeck(string(b))
+	}
+	if err != nil {
+		img.Close()
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	cr.img = img
+	cr.cards = cards
+	cr.next = 0
+	cr.busy = false
+	cr.gen++
+	return nil
+}
+
+func (cr *CardReader) Detach(drive int) error {
+	if cr.img == nil {
+		return fmt.Errorf("nothing is attached to cr%d", drive)
+	}
+	err := cr.img.Close()
+	cr.img = nil
+	cr.cards = nil
+	cr.busy = false
+	cr.gen++
+	return err
+}
+
+func (cr *CardReader) Image(drive int) *diskImage {
+	return cr.img
+}
+
+func (cr *CardReader) Select(addr uint16, mk *MK12) bool {
+	if addr != CR_DEV_DATA && addr != CR_DEV_STATUS {
+		return false
+	}
+	cr.dev = addr
+	cr.mk = mk
+	return true
+}
+
+func (cr *CardReader) Get() uint16 {
+	return cr.data
+}
+
+func (cr *CardReader) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	if cr.dev == CR_DEV_DATA {
+		if fn&RCSF != 0 {
+			skip = cr.DataReady
+		}
+		if fn&(RCRA|RCRB) != 0 {
+			cr.data = cr.column
+			if fn&RCRB == 0 {
+				cr.data = crCompress(cr.column)
+			}
+			cr.DataReady = false
+			clr, or = true, true
+		}
+		return
+	}
+	switch fn {
+	case RCSD:
+		skip = cr.CardDone
+	case RCSE:
+		skip = cr.feed()
+	case RCRD:
+		cr.CardDone = false
+	case RCSI:
+		skip = cr.DataReady || cr.CardDone
+	case RCRS:
+		cr.data = cr.status()
+		clr, or = true, true
+	}
+	return
+}
+
+// Feeds the next card from the hopper, returning false if the reader isn't
+// ready
+func (cr *CardReader) feed() bool {
+	if cr.busy || cr.img == nil || cr.next >= len(cr.cards) {
+		return false
+	}
+	card := cr.cards[cr.next]
+	cr.next++
+	cr.busy = true
+	cr.CardDone = false
+	gen := cr.gen
+	for i, column := range card {
+		column := column
+		cr.mk.schedule(time.Duration(i+1)*CR_columnTime, func() {
+			if gen == cr.gen {
+				cr.column = column
+				cr.DataReady = true
+			}
+		})
+	}
+	cr.mk.schedule(CR_cardTime, func() {
+		if gen == cr.gen {
+			cr.busy = false
+			cr.CardDone = true
+		}
+	})
+	return true
+}
+
+func (cr *CardReader) status() uint16 {
+	var st uint16
+	if cr.img == nil {
+		st |= CR_ST_NODECK | CR_ST_HOPPER | CR_ST_EOD
+	} else if cr.next >= len(cr.cards) {
+		st |= CR_ST_HOPPER
+		if !cr.busy {
+			st |= CR_ST_EOD
+		}
+	}
+	if cr.busy {
+		st |= CR_ST_BUSY
+	}
+	return st
+}
+
+func (cr *CardReader) InterruptRequest() bool {
+	return cr.DataReady || cr.CardDone
+}
+
+// CAF clears data ready and card done
+func (cr *CardReader) ClearFlags() {
+	cr.DataReady = false
+	cr.CardDone = false
+}
+
+// Returns a column in DEC's compressed alphanumeric code: the zone in bits 6
+// and 7, 1 for 12, 2 for 11 and 3 for 0, row 8 in bit 8 and rows 1 to 7 as a
+// number in bits 9 to 11, row 9 being 8 and 1
+func crCompress(column uint16) uint16 {
+	var code uint16
+	switch {
+	case column&CR_ROW12 != 0:
+		code |= 0o20
+	case column&CR_ROW11 != 0:
+		code |= 0o40
+	case column&CR_ROW0 != 0:
+		code |= 0o60
+	}
+	for n := 1; n <= 7; n++ {
+		if column&crRow(n) != 0 {
+			code |= uint16(n)
+		}
+	}
+	if column&crRow(8) != 0 {
+		code |= 0o10
+	}
+	if column&crRow(9) != 0 {
+		code |= 0o11
+	}
+	return code
+}
+
+// DEC 029 punches of the characters other than letters and digits
+var hollerith029 = map[byte]string{
+	' ': "", '&': "12", '-': "11", '/': "0-1",
+	'[': "12-8-2", '.': "12-8-3", '<': "12-8-4", '(': "12-8-5", '+': "12-8-6", '!': "12-8-7",
+	']': "11-8-2", '$': "11-8-3", '*': "11-8-4", ')': "11-8-5", ';': "11-8-6", '^': "11-8-7",
+	'\\': "0-8-2", ',': "0-8-3", '%': "0-8-4", '_': "0-8-5", '>': "0-8-6", '?': "0-8-7",
+	':': "8-2", '#': "8-3", '@': "8-4", '\'': "8-5", '=': "8-6", '"': "8-7",
+}
+
+// Returns the column punched for a character, false if it has no punches in
+// the 029 code
+func crPunch(c byte) (uint16, bool) {
+	if c >= 'a' && c <= 'z' {
+		c -= 'a' - 'A'
+	}
+	switch {
+	case c >= '0' && c <= '9':
+		if c == '0' {
+			return CR_ROW0, true
+		}
+		return crRow(int(c - '0')), true
+	case c >= 'A' && c <= 'I':
+		return CR_ROW12 | crRow(int(c-'A')+1), true
+	case c >= 'J' && c <= 'R':
+		return CR_ROW11 | crRow(int(c-'J')+1), true
+	case c >= 'S' && c <= 'Z':
+		return CR_ROW0 | crRow(int(c-'S')+2), true
+	}
+	rows, ok := hollerith029[c]
+	if !ok {
+		return 0, false
+	}
+	var column uint16
+	for _, row := range strings.Split(rows, "-") {
+		switch row {
+		case "":
+		case "12":
+			column |= CR_ROW12
+		case "11":
+			column |= CR_ROW11
+		case "0":
+			column |= CR_ROW0
+		default:
+			column |= crRow(int(row[0] - '0'))
+		}
+	}
+	return column, true
+}
+
+// Punches a text deck, a card a line with tabs every eight columns
+func textDeck(text string) ([][CR_COLUMNS]uint16, error) {
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	var cards [][CR_COLUMNS]uint16
+	for n, line := range strings.Split(text, "\n") {
+		var card [CR_COLUMNS]uint16
+		col := 0
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\t' {
+				col = (col + 8) &^ 7
+				continue
+			}
+			if col >= CR_COLUMNS {
+				return nil, fmt.Errorf("line %d is longer than %d columns", n+1, CR_COLUMNS)
+			}
+			column, ok := crPunch(line[i])
+			if !ok {
+				return nil, fmt.Errorf("line %d: %q can't be punched", n+1, line[i])
+			}
+			card[col] = column
+			col++
+		}
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+// Reads a deck of column images
+func columnDeck(b []byte) ([][CR_COLUMNS]uint16, error) {
+	if len(b)%(2*CR_COLUMNS) != 0 {
+		return nil, fmt.Errorf("not a whole number of %d column cards", CR_COLUMNS)
+	}
+	cards := make([][CR_COLUMNS]uint16, len(b)/(2*CR_COLUMNS))
+	for i := range cards {
+		for c := range cards[i] {
+			cards[i][c] = binary.LittleEndian.Uint16(b[(i*CR_COLUMNS+c)*2:]) & 0o7777
+		}
+	}
+	return cards, nil
+}
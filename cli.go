@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 )
 
 type CLIArgs struct {
@@ -15,6 +17,36 @@ type CLIArgs struct {
 
 	NoGui bool
 
+	// Serve a web front panel on this address instead of the curses ui
+	Web      string
+	WebFiles bool
+
+	// Serve the teletype over telnet on this address
+	Telnet string
+
+	// More KL8-E serial lines, as code=backend
+	KL8E stringList
+
+	// DR8-E parallel I/O, as code=backend
+	DR8 stringList
+
+	// Devices simulated by other processes, as sock:path
+	Plugins stringList
+
+	// Teletype personality
+	TTY string
+
+	// Image files to attach to drives, as drive=path[,ro]
+	Attach stringList
+
+	// Drive to boot from, as drive[=path[,ro]]
+	Boot string
+
+	// Real-time clock rate in Hz, 0 for none, and whether it keeps wall clock
+	// time
+	Clock     int
+	ClockWall bool
+
 	Return bool // Print AC before exiting
 
 	// HALT on startup
@@ -25,17 +57,72 @@ type CLIArgs struct {
 	// Clock Speed
 	F_CPU int64
 
-	// File[path] to use as virtual paper tape
-	// TapeFile  string
-	// iTapeFile string
-	// oTapeFile string
+	// Pace execution to the instruction timing of the model
+	Realtime bool
+
+	// Processor model
+	Model string
+
+	// Install the extended arithmetic element
+	EAE bool
+
+	// Memory size in K words
+	Memory int
+
+	// Object file[path] to load into HD-6120 control panel memory
+	Panel string
+
+	// Files[path] to read and punch paper tape
+	Tape  string
+	Punch string
+
+	// Built-in loader to deposit and start
+	Loader string
+
+	// File[path] to print to, - for stdout, and the printer's columns, lines
+	// a page and green bar mode
+	LPT         string
+	LPTColumns  int
+	LPTLines    int
+	LPTGreenBar bool
+
+	// Install a VC8-E display, its phosphor's time constant, and a directory
+	// to write PNG frames to at a rate
+	VC8       bool
+	VC8Decay  time.Duration
+	VC8Frames string
+	VC8FPS    int
+
+	// Plotter step size and paper size
+	PlotStep  string
+	PlotPaper string
 
 	// Lock memory viewer to page
 	Page int
+
+	// File[path] to write an instruction trace to
+	Trace string
+
+	// Print execution statistics before exiting
+	Stats bool
+}
+
+// A flag that can be given more than once
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
 
 func printUsage() {
 	fmt.Println("Usage:", os.Args[0], "[options] <in_file>")
+	fmt.Println("      ", os.Args[0], "[options] -boot drive[=path[,ro]] [in_file]")
+	fmt.Println("      ", os.Args[0], "[options] -loader name [in_file]")
 	fmt.Printf("\nOptions:\n")
 	flag.PrintDefaults()
 }
@@ -49,7 +136,14 @@ func parseArgs() CLIArgs {
 	flag.Usage = printUsage
 
 	// Add flags
-	flag.Int64Var(&args.F_CPU, "F_CPU", 8000000, "simulated clock `speed`")
+	flag.Int64Var(&args.F_CPU, "F_CPU", 8000000, "simulated clock `speed` (0 runs unthrottled)")
+
+	flag.BoolVar(&args.Realtime, "realtime", false, "Run at the speed of the real machine instead of F_CPU")
+
+	flag.StringVar(&args.Model, "model", "8e", "Processor `model` to simulate (pdp8, 8i, 8l, 8e, 6100, 6120)")
+	flag.BoolVar(&args.EAE, "eae", false, "Install the extended arithmetic element (pdp8, 8i, 8e)")
+	flag.IntVar(&args.Memory, "memory", 0, "Memory `size` in K words (4, 8, 16, 32), 32 on the 6120 and 4 otherwise")
+	flag.StringVar(&args.Panel, "panel", "", "Load control panel memory from `path` and power up in panel mode (6120)")
 
 	flag.IntVar(&args.Page, "lock", -1, "Lock memory viewer to `page`")
 
@@ -57,12 +151,36 @@ func parseArgs() CLIArgs {
 	flag.BoolVar(&args.EXIT, "exit", false, "Exit the simulator on HALT")
 
 	flag.BoolVar(&args.NoGui, "no-gui", false, "Do not display curses ui")
+	flag.StringVar(&args.Telnet, "telnet", "", "Serve the teletype over telnet on `address` (host:port)")
+	flag.Var(&args.KL8E, "kl8e", "Add a KL8-E serial `line` as code=backend, backend is stdin, file:in[,out], tcp:host:port or pty (repeatable)")
+	flag.Var(&args.DR8, "dr8", "Add a DR8-E parallel I/O `interface` as code=backend, backend is file:in[,out] or loop (repeatable)")
+	flag.Var(&args.Plugins, "device", "Add devices simulated by a plugin listening on a unix socket, as `sock:path` (repeatable)")
+	flag.Var(&args.Attach, "attach", "Attach an image file to a drive as `drive=path[,ro]`, e.g. rk0=os8.rk05 (repeatable)")
+	flag.StringVar(&args.Boot, "boot", "", "Boot from a drive as `drive[=path[,ro]]`, attaching path and starting the controller's bootstrap, e.g. rk0=os8.rk05")
+	flag.IntVar(&args.Clock, "clock", 0, "Install a DK8-E real-time clock ticking at `hz` (50 or 60 for line frequency, or a crystal rate)")
+	flag.BoolVar(&args.ClockWall, "clock-wall", false, "Tick the real-time clock in wall clock time instead of simulated time")
+	flag.StringVar(&args.TTY, "tty", "modern", "Teletype `personality`: modern passes characters through, asr33 behaves like a Model 33")
+	flag.StringVar(&args.Web, "web", "", "Serve a web front panel on `address` (host:port) instead of the curses ui")
+	flag.BoolVar(&args.WebFiles, "web-files", false, "Let the web front panel run debug commands that touch host files (attach, detach, tape, snapshot)")
 
 	flag.BoolVar(&args.Return, "print-return", false, "Print return code (AC) upon exiting")
-
-	// flag.StringVar(&args.TapeFile, "tape", "mk-12.tape", "Specify `path` to file for virtual tape reader/punch")
-	// flag.StringVar(&args.iTapeFile, "itape", "", "Specify `path` to file for virtual tape reader")
-	// flag.StringVar(&args.oTapeFile, "otape", "", "Specify `path` to file for virtual tape punch")
+	flag.BoolVar(&args.Stats, "stats", false, "Print instructions executed, instructions/second and data break cycles upon exiting")
+
+	flag.StringVar(&args.Trace, "trace", "", "Write a trace of every executed instruction to `path`")
+
+	flag.StringVar(&args.Tape, "tape", "", "Load the paper tape in `path` into the PC8-E reader")
+	flag.StringVar(&args.Punch, "punch", "", "Punch paper tape into the file at `path`")
+	flag.StringVar(&args.LPT, "lpt", "", "Install an LE8 line printer printing to `path` (- for stdout)")
+	flag.IntVar(&args.LPTColumns, "lpt-columns", 132, "Line printer `columns`")
+	flag.IntVar(&args.LPTLines, "lpt-lines", 66, "Line printer `lines` a page")
+	flag.BoolVar(&args.LPTGreenBar, "lpt-greenbar", false, "Print on plain text green bar paper")
+	flag.BoolVar(&args.VC8, "vc8", false, "Install a VC8-E point plot display, shown with Ctrl+V in the curses ui")
+	flag.DurationVar(&args.VC8Decay, "vc8-decay", 100*time.Millisecond, "Phosphor decay `time` of the VC8-E, to 1/e of its brightness")
+	flag.StringVar(&args.VC8Frames, "vc8-frames", "", "Write VC8-E PNG frames to `dir`, installing the display")
+	flag.IntVar(&args.VC8FPS, "vc8-fps", 25, "VC8-E frames a second of simulated time")
+	flag.StringVar(&args.PlotStep, "plot-step", "0.01in", "Plotter step `size` in in or mm")
+	flag.StringVar(&args.PlotPaper, "plot-paper", "11x17in", "Plotter paper `size`, WxH in in or mm")
+	flag.StringVar(&args.Loader, "loader", "", "Deposit the built-in loader `name`, such as rim or bin, and start it")
 
 	help := flag.Bool("help", false, "Print this message and exit")
 
@@ -74,20 +192,18 @@ func parseArgs() CLIArgs {
 		os.Exit(0)
 	}
 
-	// if args.iTapeFile == "" {
-	// 	args.iTapeFile = args.TapeFile
-	// }
-	// if args.oTapeFile == "" {
-	// 	args.oTapeFile = args.TapeFile
-	// }
-
-	// Get remaining positional argument (infile)
+	// Get remaining positional argument (infile), which is optional when
+	// booting or starting a loader
 	if len(flag.Args()) == 1 {
 		args.InFile = flag.Arg(0)
-	} else {
+	} else if len(flag.Args()) > 1 || args.Boot == "" && args.Loader == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
+	if args.Boot != "" && args.Loader != "" {
+		fmt.Fprintln(os.Stderr, "ERROR: -boot and -loader can't be used together")
+		os.Exit(1)
+	}
 
 	return args
 }
@@ -111,9 +227,30 @@ func (fp *CLIFrontPanel) ReadSwitches() uint16 {
 	return 0
 }
 
+func (fp *CLIFrontPanel) ReadFieldSwitches() (uint16, uint16) {
+	return 0, 0
+}
+
+func (fp *CLIFrontPanel) ReadKey() PanelKey {
+	return KEY_NONE
+}
+
+func (fp *CLIFrontPanel) ReadCommand() string {
+	return ""
+}
+
+func (fp *CLIFrontPanel) Print(msg string) {
+	fmt.Fprintln(os.Stderr, msg)
+}
+
+// StdinKeyboard reads the teletype keyboard from stdin. Keys are read by a
+// goroutine in the background so the machine never waits on the terminal.
+// It is started the first time the program looks at the keyboard, so stdin is
+// left alone for programs that don't.
 type StdinKeyboard struct {
 	Stdin             *os.File
-	lastKey           []byte
+	keys              chan byte
+	reader            sync.Once
 	originalSttyState bytes.Buffer
 }
 
@@ -138,10 +275,10 @@ func (sk *StdinKeyboard) setSttyState(state *bytes.Buffer) (err error) {
 	return cmd.Run()
 }
 
-func NewStdinKeyboard() (sk StdinKeyboard) {
-	sk = StdinKeyboard{
-		Stdin:   os.Stdin,
-		lastKey: make([]byte, 1),
+func NewStdinKeyboard() (sk *StdinKeyboard) {
+	sk = &StdinKeyboard{
+		Stdin: os.Stdin,
+		keys:  make(chan byte, 256),
 	}
 	sk.saveSttyState()
 	defer sk.ResetSttyState()
@@ -162,21 +299,32 @@ func NewStdinKeyboard() (sk StdinKeyboard) {
 	return sk
 }
 
-func (sk StdinKeyboard) Buffered() (buffered int) {
-	buffered, err := sk.Stdin.Read(sk.lastKey)
-	if err != nil {
-		if err == io.EOF {
-			// sk.Stdin.Seek(0, 0)
-			return 0
+// Reads keys until stdin is closed
+func (sk *StdinKeyboard) read() {
+	buf := make([]byte, 1)
+	for {
+		n, err := sk.Stdin.Read(buf)
+		if err != nil {
+			return
+		}
+		if n > 0 {
+			sk.keys <- buf[0]
 		}
-		panic(err)
 	}
-	return
 }
 
-func (sk StdinKeyboard) ReadByte() (char byte, err error) {
-	char = sk.lastKey[0]
-	return
+func (sk *StdinKeyboard) Buffered() int {
+	sk.reader.Do(func() { go sk.read() })
+	return len(sk.keys)
+}
+
+func (sk *StdinKeyboard) ReadByte() (byte, error) {
+	select {
+	case c := <-sk.keys:
+		return c, nil
+	default:
+		return 0, nil
+	}
 }
 
 func (sk *StdinKeyboard) ResetSttyState() {
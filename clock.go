@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// DK8-E real-time clock device code
+const CLK_DEV = 0o13
+
+// DK8-E clock function codes
+const (
+	CLEI = 0o1 // Enable clock interrupts
+	CLDI = 0o2 // Disable clock interrupts
+	CLSK = 0o3 // Skip on clock flag and clear it
+)
+
+// RealTimeClock is a DK8-E clock: the DK8-EA ticks at the line frequency (50
+// or 60 Hz) and the DK8-EC at a crystal rate. Every tick sets the clock flag,
+// which interrupts if clock interrupts are enabled. Ticks are counted in
+// simulated time, so a program sees the same number of ticks per instruction
+// however fast the simulator runs, or with Wall in wall clock time so they
+// keep real time.
+type RealTimeClock struct {
+	Device
+
+	// Ticks per second
+	Rate int
+
+	// Tick in wall clock time rather than simulated time
+	Wall bool
+
+	// Clock flag and interrupt enable
+	Flag bool
+	IE   bool
+
+	// Wall clock ticks not seen yet
+	ticks atomic.Int32
+}
+
+// Creates a clock ticking rate times a second and starts it
+func NewRealTimeClock(mk *MK12, rate int, wall bool) *RealTimeClock {
+	clk := &RealTimeClock{Rate: rate, Wall: wall}
+	period := time.Second / time.Duration(rate)
+	if wall {
+		go func() {
+			for range time.Tick(period) {
+				clk.ticks.Add(1)
+			}
+		}()
+	} else {
+		var tick func()
+		tick = func() {
+			clk.Flag = true
+			mk.schedule(period, tick)
+		}
+		mk.schedule(period, tick)
+	}
+	return clk
+}
+
+func (clk *RealTimeClock) Select(addr uint16, mk *MK12) bool {
+	return addr == CLK_DEV
+}
+
+func (clk *RealTimeClock) Get() uint16 {
+	return 0
+}
+
+// Sets the flag for wall clock ticks that happened since last time
+func (clk *RealTimeClock) poll() {
+	if clk.Wall && clk.ticks.Load() > 0 {
+		clk.ticks.Store(0)
+		clk.Flag = true
+	}
+}
+
+func (clk *RealTimeClock) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	clk.poll()
+	switch fn {
+	case CLEI:
+		clk.IE = true
+	case CLDI:
+		clk.IE = false
+	case CLSK:
+		skip = clk.Flag
+		clk.Flag = false
+	}
+	return
+}
+
+func (clk *RealTimeClock) InterruptRequest() bool {
+	if !clk.IE {
+		return false
+	}
+	clk.poll()
+	return clk.Flag
+}
+
+// CAF clears the flag and disables clock interrupts
+func (clk *RealTimeClock) ClearFlags() {
+	clk.poll()
+	clk.Flag = false
+	clk.IE = false
+}
@@ -4,13 +4,28 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"time"
 
 	"github.com/jroimartin/gocui"
 )
 
-var lastKey byte
+var lastKey PanelKey
 var switchRegister uint16
 
+// Instruction and data field switches
+var instFieldSwitches uint16
+var dataFieldSwitches uint16
+
+// If displayMD is set the console display shows MD instead of CPMA
+var displayMD bool
+var displayCPMA, displayMB uint16
+
+// VC8-E channel shown over the console, 0 for none, the size of its view and
+// when it was last drawn
+var showVC8 int
+var vc8Cols, vc8Rows int
+var vc8Drawn time.Time
+
 type CUIFrontPanel struct {
 	g                *gocui.Gui
 	MemoryViewerPage int
@@ -24,6 +39,16 @@ func (fp *CUIFrontPanel) PowerOn(mk MK12) {
 	}
 	fp.g = g
 
+	// Esc is a key of its own (halt, leave the memory viewer) rather than Alt
+	g.InputEsc = true
+
+	// -lock starts the memory viewer on a page instead of following the PC
+	if 0 <= fp.MemoryViewerPage && fp.MemoryViewerPage <= 0o7777 {
+		memView.page = uint16(fp.MemoryViewerPage) & 0o7600
+		memView.locked = true
+	}
+	memView.want()
+
 	// Layout
 	g.SetManagerFunc(layout)
 
@@ -39,7 +64,41 @@ func (fp *CUIFrontPanel) PowerOn(mk MK12) {
 	if err := g.SetKeybinding("", gocui.KeySpace, gocui.ModNone, step); err != nil {
 		log.Panicln(err)
 	}
-	if err := g.SetKeybinding("", gocui.KeyHome, gocui.ModNone, loadPC); err != nil {
+	if err := g.SetKeybinding("", gocui.KeyTab, gocui.ModNone, cycleStep); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyEsc, gocui.ModNone, haltMachine); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyHome, gocui.ModNone, loadAddress); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyInsert, gocui.ModNone, deposit); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyEnd, gocui.ModNone, examine); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyDelete, gocui.ModNone, clearMachine); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyCtrlP, gocui.ModNone, toggleDisplay); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyCtrlV, gocui.ModNone, toggleVC8); err != nil {
+		log.Panicln(err)
+	}
+
+	// Ctrl+F and Ctrl+D step through the instruction and data field switches
+	if err := g.SetKeybinding("", gocui.KeyCtrlF, gocui.ModNone, instField); err != nil {
+		log.Panicln(err)
+	}
+	if err := g.SetKeybinding("", gocui.KeyCtrlD, gocui.ModNone, dataField); err != nil {
+		log.Panicln(err)
+	}
+
+	// Memory viewer navigation and editing
+	if err := bindMemoryViewer(g); err != nil {
 		log.Panicln(err)
 	}
 
@@ -109,25 +168,49 @@ func (fp *CUIFrontPanel) Update(mk MK12) {
 	updateRegister(fp.g, "address-register", mk.MA)
 	updateRegister(fp.g, "buffer-register", mk.MB)
 	updateRegister(fp.g, "switch-register", mk.SR)
+	updateFields(fp.g, mk.IF, mk.DF)
+	displayCPMA = mk.IF<<12 | mk.MA
+	displayMB = mk.MB
+	updateDisplay(fp.g)
 	debugPrint(fp.g, mk.IRd)
 
-	if 0 <= fp.MemoryViewerPage && fp.MemoryViewerPage <= 0o7777 {
-		updateMemory(fp.g, mk.MEM, uint16(fp.MemoryViewerPage)&0b0000111110000000)
-	} else {
-		updateMemory(fp.g, mk.MEM, mk.PC&0b0000111110000000)
-	}
+	updateMemory(fp.g, &mk)
 	updateZeroMemory(fp.g, mk.MEM)
+	updateVC8(fp.g, &mk)
 }
 
 func (fp *CUIFrontPanel) ReadSwitches() uint16 {
 	return switchRegister
 }
 
+func (fp *CUIFrontPanel) ReadFieldSwitches() (uint16, uint16) {
+	return instFieldSwitches, dataFieldSwitches
+}
+
+func (fp *CUIFrontPanel) ReadKey() PanelKey {
+	key := lastKey
+	lastKey = KEY_NONE
+	return key
+}
+
+func (fp *CUIFrontPanel) ReadCommand() string {
+	select {
+	case line := <-panelCommands:
+		return line
+	default:
+		return ""
+	}
+}
+
+func (fp *CUIFrontPanel) Print(msg string) {
+	debugPrint(fp.g, msg)
+}
+
 func layout(g *gocui.Gui) error {
 	maxX, maxY := g.Size()
 
 	// Register size
-	regNum := 6
+	regNum := 8
 	regWStart := 0
 	regWidth := 15
 	regWEnd := regWStart + regWidth
@@ -139,6 +222,7 @@ func layout(g *gocui.Gui) error {
 	// Memory size
 	memWEnd := maxX - 1
 	memWidth := 45 // (4 octal numbers * 8 columns + 7 spaces in between + 2 on the outside + 1 extra + 3 for address)
+	memWidth += memView.textWidth()
 	memWStart := memWEnd - memWidth
 	memHEnd := maxY - 1
 	memHeight := 18 // 16 lines(rows) of 8 locations(cols) gives us a total of 128
@@ -222,12 +306,30 @@ func layout(g *gocui.Gui) error {
 	}
 	regHStart = regHEnd + 1
 	regHEnd = regHStart + regHeight
+	// Console display, selectable between CPMA and MD
+	if v, err := g.SetView("display-register", regWStart, regHStart, regWEnd, regHEnd); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = " CPMA "
+	}
+	regHStart = regHEnd + 1
+	regHEnd = regHStart + regHeight
+	// Instruction and Data Field
+	if v, err := g.SetView("field-register", regWStart, regHStart, regWEnd, regHEnd); err != nil {
+		if err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = " FIELDS "
+	}
+	regHStart = regHEnd + 1
+	regHEnd = regHStart + regHeight
 	// Switch Register
 	if v, err := g.SetView("switch-register", regWStart, regHStart, regWEnd, regHEnd); err != nil {
 		if err != gocui.ErrUnknownView {
 			return err
 		}
-		v.Title = " SR "
+		v.Title = fmt.Sprintf(" SR  IF%o DF%o ", instFieldSwitches, dataFieldSwitches)
 	}
 
 	// Memory Viewer
@@ -258,6 +360,18 @@ func layout(g *gocui.Gui) error {
 		v.Autoscroll = true
 	}
 
+	// VC8-E display, over the teletype
+	if showVC8 != 0 {
+		v, err := g.SetView("vc8", consoleWStart, consoleHStart, consoleWEnd, consoleHEnd)
+		if err != nil && err != gocui.ErrUnknownView {
+			return err
+		}
+		v.Title = fmt.Sprintf(" VC8-E CHANNEL %d ", showVC8)
+		vc8Cols, vc8Rows = v.Size()
+	} else if err := g.DeleteView("vc8"); err != nil && err != gocui.ErrUnknownView {
+		return err
+	}
+
 	// Debug command console
 	if v, err := g.SetView("dbg-console", dconsoleWStart, dconsoleHStart, dconsoleWEnd, dconsoleHEnd); err != nil {
 		if err != gocui.ErrUnknownView {
@@ -267,6 +381,21 @@ func layout(g *gocui.Gui) error {
 		v.Autoscroll = true
 	}
 
+	// Goto address/command prompt, over the bottom of the debug console
+	if memView.prompting {
+		if v, err := g.SetView("prompt", dconsoleWStart, dconsoleHEnd-2, dconsoleWEnd, dconsoleHEnd); err != nil {
+			if err != gocui.ErrUnknownView {
+				return err
+			}
+			v.Title = " GOTO ADDRESS OR COMMAND "
+			v.Editable = true
+			if _, err := g.SetCurrentView("prompt"); err != nil {
+				return err
+			}
+			g.Cursor = true
+		}
+	}
+
 	// Program Title Text
 	if v, err := g.SetView("title-text", titleWStart, titleHStart, titleWEnd, titleHEnd); err != nil {
 		if err != gocui.ErrUnknownView {
@@ -295,17 +424,76 @@ func quit(g *gocui.Gui, v *gocui.View) error {
 }
 
 func proceed(g *gocui.Gui, v *gocui.View) error {
-	lastKey = '\n'
-	return nil
+	return pressKey(v, KEY_CONT)
 }
 
 func step(g *gocui.Gui, v *gocui.View) error {
-	lastKey = ' '
+	return pressKey(v, KEY_INST)
+}
+
+func cycleStep(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_STEP)
+}
+
+func haltMachine(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_HALT)
+}
+
+func loadAddress(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_LOAD)
+}
+
+func deposit(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_DEP)
+}
+
+func examine(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_EXAM)
+}
+
+func clearMachine(g *gocui.Gui, v *gocui.View) error {
+	return pressKey(v, KEY_CLEAR)
+}
+
+// Front panel keys are ignored while the memory viewer or prompt has the
+// keyboard, so typing there doesn't run the machine.
+func pressKey(v *gocui.View, key PanelKey) error {
+	if v != nil && (v.Name() == "memory" || v.Name() == "prompt") {
+		return nil
+	}
+	lastKey = key
+	return nil
+}
+
+func toggleDisplay(g *gocui.Gui, v *gocui.View) error {
+	displayMD = !displayMD
+	updateDisplay(g)
 	return nil
 }
 
-func loadPC(g *gocui.Gui, v *gocui.View) error {
-	lastKey = 17 // Device Control 1 is Load Program Counter
+// Steps the console between the teletype and the VC8-E's two channels
+func toggleVC8(g *gocui.Gui, v *gocui.View) error {
+	showVC8 = (showVC8 + 1) % 3
+	vc8Drawn = time.Time{}
+	return nil
+}
+
+func instField(g *gocui.Gui, v *gocui.View) error {
+	instFieldSwitches = (instFieldSwitches + 1) % 8
+	return updateFieldSwitches(g)
+}
+
+func dataField(g *gocui.Gui, v *gocui.View) error {
+	dataFieldSwitches = (dataFieldSwitches + 1) % 8
+	return updateFieldSwitches(g)
+}
+
+func updateFieldSwitches(g *gocui.Gui) error {
+	v, err := g.View("switch-register")
+	if err != nil {
+		return err
+	}
+	v.Title = fmt.Sprintf(" SR  IF%o DF%o ", instFieldSwitches, dataFieldSwitches)
 	return nil
 }
 
@@ -370,18 +558,6 @@ func switchRegister12(g *gocui.Gui, v *gocui.View) error {
 	return nil
 }
 
-var b byte
-
-func getLastKey() byte {
-	if lastKey != 0 {
-		b = lastKey
-		lastKey = 0
-	} else {
-		b = 0
-	}
-	return b
-}
-
 func updateRegister(g *gocui.Gui, registerName string, registerVal uint16) {
 	g.Update(func(g *gocui.Gui) error {
 		v, err := g.View(registerName)
@@ -394,6 +570,37 @@ func updateRegister(g *gocui.Gui, registerName string, registerVal uint16) {
 	})
 }
 
+// Shows CPMA (with the field) or MD in the console display
+func updateDisplay(g *gocui.Gui) {
+	g.Update(func(g *gocui.Gui) error {
+		v, err := g.View("display-register")
+		if err != nil {
+			return err
+		}
+		v.Clear()
+		if displayMD {
+			v.Title = " MD "
+			fmt.Fprintf(v, " %12.12b ", displayMB)
+		} else {
+			v.Title = fmt.Sprintf(" CPMA %o ", displayCPMA>>12)
+			fmt.Fprintf(v, " %12.12b ", displayCPMA&0o7777)
+		}
+		return nil
+	})
+}
+
+func updateFields(g *gocui.Gui, ifield, dfield uint16) {
+	g.Update(func(g *gocui.Gui) error {
+		v, err := g.View("field-register")
+		if err != nil {
+			return err
+		}
+		v.Clear()
+		fmt.Fprintf(v, "  IF %o   DF %o", ifield, dfield)
+		return nil
+	})
+}
+
 func debugPrint(g *gocui.Gui, msg string) {
 	g.Update(func(g *gocui.Gui) error {
 		v, err := g.View("dbg-console")
@@ -420,21 +627,17 @@ func updateStatus(g *gocui.Gui, status string, atr gocui.Attribute) {
 	})
 }
 
-// Updates the memory view
-// Takes the mem array and the page to display
-func updateMemory(g *gocui.Gui, mem [4096]uint16, page uint16) {
-	memRow := 0o10
-	var memStr = fmt.Sprintf("%02o00   0    1    2    3    4    5    6    7\n", page>>6)
-	memStr += fmt.Sprintf("00  %04o ", mem[page])
-	for loc := page + 1; loc < page+128; loc++ {
+func updateZeroMemory(g *gocui.Gui, mem []uint16) {
+	var memStr = "0000   0    1    2    3    4    5    6    7\n"
+	memStr += fmt.Sprintf("00  %04o ", mem[0])
+	for loc := 1; loc < 16; loc++ {
 		memStr += fmt.Sprintf("%04o ", mem[loc])
-		if (loc+1)%8 == 0 && loc+1 < page+128 {
-			memStr += fmt.Sprintf("\n%02.2o  ", memRow)
-			memRow = (memRow + 0o10) % 0o100
+		if loc == 7 {
+			memStr += "\n10  "
 		}
 	}
 	g.Update(func(g *gocui.Gui) error {
-		v, err := g.View("memory")
+		v, err := g.View("memory-zero")
 		if err != nil {
 			return err
 		}
@@ -444,22 +647,32 @@ func updateMemory(g *gocui.Gui, mem [4096]uint16, page uint16) {
 	})
 }
 
-func updateZeroMemory(g *gocui.Gui, mem [4096]uint16) {
-	var memStr = "0000   0    1    2    3    4    5    6    7\n"
-	memStr += fmt.Sprintf("00  %04o ", mem[0])
-	for loc := 1; loc < 16; loc++ {
-		memStr += fmt.Sprintf("%04o ", mem[loc])
-		if loc == 7 {
-			memStr += "\n10  "
-		}
+// Draws the VC8-E channel being shown in braille, square in the middle of its
+// view. The whole screen is scanned, so it is only redrawn every 50ms.
+func updateVC8(g *gocui.Gui, mk *MK12) {
+	vc := mk.vc8()
+	if showVC8 == 0 || vc == nil || time.Since(vc8Drawn) < 50*time.Millisecond {
+		return
+	}
+	vc8Drawn = time.Now()
+	side := vc8Cols * 2
+	if vc8Rows*4 < side {
+		side = vc8Rows * 4
+	}
+	if side < 4 {
+		return
 	}
+	lines := vc.Braille(showVC8-1, side/2, side/4)
+	indent := fmt.Sprintf("%*s", (vc8Cols-side/2)/2, "")
 	g.Update(func(g *gocui.Gui) error {
-		v, err := g.View("memory-zero")
+		v, err := g.View("vc8")
 		if err != nil {
-			return err
+			return nil
 		}
 		v.Clear()
-		fmt.Fprint(v, memStr)
+		for _, line := range lines {
+			fmt.Fprintln(v, indent+line)
+		}
 		return nil
 	})
 }
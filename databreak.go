@@ -0,0 +1,59 @@
+package main
+
+import "fmt"
+
+// Data break lets devices move words to and from memory without the program,
+// stealing memory cycles from the processor between instructions. Every
+// break cycle adds a memory cycle to the simulated time (so the processor
+// runs that much slower), is counted by the throttle and is written to the
+// trace.
+//
+// A single cycle break is one memory cycle at an address the device gives.
+// A three cycle break keeps the word count and current address in two words
+// of field 0: the first cycle increments the word count, the second the
+// current address and the third moves the word at the current address. It
+// is started with threeCycle and finished with breakIn or breakOut.
+
+// Memory cycle stolen for a data break
+func (mk *MK12) steal(what string, addr uint16, data uint16) {
+	mk.HW.TIME += mk.HW.MODEL.Cycle
+	mk.HW.BREAKS++
+	mk.stolen++
+	if mk.trace != nil {
+		fmt.Fprintf(mk.trace, "BRK   %04o  %s %05o\n", data, what, addr)
+	}
+}
+
+// Single cycle data break into memory, the address wraps around installed
+// memory
+func (mk *MK12) breakIn(addr uint16, data uint16) {
+	addr &= uint16(len(mk.MEM) - 1)
+	mk.write(addr, data)
+	mk.steal("IN", addr, data)
+}
+
+// Single cycle data break out of memory
+func (mk *MK12) breakOut(addr uint16) uint16 {
+	addr &= uint16(len(mk.MEM) - 1)
+	data := mk.MEM[addr]
+	mk.steal("OUT", addr, data)
+	return data
+}
+
+// The word count and current address cycles of a three cycle data break,
+// with the word count at wc and the current address at wc+1. The current
+// address is not incremented if incCA is false. Returns the address in field
+// for the data cycle, and true if the word count overflowed.
+func (mk *MK12) threeCycle(wc uint16, field uint16, incCA bool) (addr uint16, overflow bool) {
+	count := (mk.MEM[wc] + 1) & 0o7777
+	mk.write(wc, count)
+	mk.steal("WC", wc, count)
+
+	ca := mk.MEM[wc+1]
+	if incCA {
+		ca = (ca + 1) & 0o7777
+		mk.write(wc+1, ca)
+	}
+	mk.steal("CA", wc+1, ca)
+	return (field&mk.fieldMask)<<12 | ca, count == 0
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A DebugCommand is a command that can be typed at the debug console. Commands
+// run on the CPU between instructions, so they may change the machine freely.
+type DebugCommand struct {
+	// Argument synopsis shown by help
+	Usage string
+
+	// One line description shown by help
+	Help string
+
+	// Runs the command with the words following its name
+	Run func(mk *MK12, args []string) (string, error)
+
+	// Reads or writes files on the host, or deposits over memory. The web
+	// panel only runs these with -web-files.
+	Files bool
+}
+
+// Debug console commands by name
+var debugCommands = map[string]DebugCommand{}
+
+// Short names for debug console commands
+var debugAliases = map[string]string{}
+
+func init() {
+	debugCommands["help"] = DebugCommand{
+		Help: "List debug console commands",
+		Run:  cmdHelp,
+	}
+	debugCommands["deposit"] = DebugCommand{
+		Usage: "<addr> <word>...",
+		Help:  "Store words in memory starting at the 15-bit octal address",
+		Run:   cmdDeposit,
+	}
+	debugCommands["examine"] = DebugCommand{
+		Usage: "<addr> [count]",
+		Help:  "Print words of memory starting at the 15-bit octal address",
+		Run:   cmdExamine,
+	}
+	debugCommands["break"] = DebugCommand{
+		Usage: "[addr]",
+		Help:  "Toggle a breakpoint at the 15-bit octal address, or list them",
+		Run:   cmdBreak,
+	}
+	debugAliases["d"] = "deposit"
+	debugAliases["e"] = "examine"
+	debugAliases["b"] = "break"
+}
+
+// Runs a debug console command line, returning its output
+func (mk *MK12) command(line string) (string, error) {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return "", nil
+	}
+	cmd, ok := lookupCommand(words[0])
+	if !ok {
+		return "", fmt.Errorf("unknown command %q, try help", words[0])
+	}
+	return cmd.Run(mk, words[1:])
+}
+
+// Finds a debug command by name or alias
+func lookupCommand(word string) (DebugCommand, bool) {
+	name := strings.ToLower(word)
+	if alias, ok := debugAliases[name]; ok {
+		name = alias
+	}
+	cmd, ok := debugCommands[name]
+	return cmd, ok
+}
+
+// Runs any command waiting at the front panel and prints the result.
+// Returns true if a command was run.
+func (mk *MK12) pollCommand() bool {
+	line := mk.fp.ReadCommand()
+	if line == "" {
+		return false
+	}
+	out, err := mk.command(line)
+	if err != nil {
+		mk.fp.Print("ERROR: " + err.Error())
+	} else if out != "" {
+		mk.fp.Print(out)
+	}
+	return true
+}
+
+// Parses an octal word no larger than max
+func parseOctal(s string, max uint16) (uint16, error) {
+	v, err := strconv.ParseUint(s, 8, 16)
+	if err != nil || v > uint64(max) {
+		return 0, fmt.Errorf("bad octal value %q", s)
+	}
+	return uint16(v), nil
+}
+
+// Parses a 15-bit octal address that must be in installed memory
+func (mk *MK12) parseAddress(s string) (uint16, error) {
+	addr, err := parseOctal(s, 0o77777)
+	if err != nil {
+		return 0, err
+	}
+	if int(addr) >= len(mk.MEM) {
+		return 0, fmt.Errorf("address %05o is past the end of memory", addr)
+	}
+	return addr, nil
+}
+
+func cmdHelp(mk *MK12, args []string) (string, error) {
+	var names []string
+	for name := range debugCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		cmd := debugCommands[name]
+		lines = append(lines, fmt.Sprintf("%-24s %s", strings.TrimSpace(name+" "+cmd.Usage), cmd.Help))
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func cmdDeposit(mk *MK12, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", fmt.Errorf("usage: deposit <addr> <word>...")
+	}
+	addr, err := mk.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+	for _, arg := range args[1:] {
+		word, err := parseOctal(arg, 0o7777)
+		if err != nil {
+			return "", err
+		}
+		mk.write(addr, word)
+		addr = (addr + 1) % uint16(len(mk.MEM))
+	}
+	return "", nil
+}
+
+func cmdExamine(mk *MK12, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", fmt.Errorf("usage: examine <addr> [count]")
+	}
+	addr, err := mk.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+	count := uint16(1)
+	if len(args) == 2 {
+		if count, err = parseOctal(args[1], 0o7777); err != nil {
+			return "", err
+		}
+	}
+	var lines []string
+	for i := uint16(0); i < count; i += 8 {
+		line := fmt.Sprintf("%05o:", addr)
+		for j := i; j < count && j < i+8; j++ {
+			line += fmt.Sprintf(" %04o", mk.MEM[addr])
+			addr = (addr + 1) % uint16(len(mk.MEM))
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func cmdBreak(mk *MK12, args []string) (string, error) {
+	if len(args) == 0 {
+		var lines []string
+		for addr, set := range mk.breakpoints {
+			if set {
+				lines = append(lines, fmt.Sprintf("%05o", addr))
+			}
+		}
+		if len(lines) == 0 {
+			return "no breakpoints", nil
+		}
+		return strings.Join(lines, " "), nil
+	}
+	addr, err := mk.parseAddress(args[0])
+	if err != nil {
+		return "", err
+	}
+	if mk.breakpoints == nil {
+		mk.breakpoints = make([]bool, len(mk.MEM))
+	}
+	mk.breakpoints[addr] = !mk.breakpoints[addr]
+	if mk.breakpoints[addr] {
+		return fmt.Sprintf("breakpoint set at %05o", addr), nil
+	}
+	return fmt.Sprintf("breakpoint cleared at %05o", addr), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A decodedOp holds the parts of an instruction word that only depend on the
+// word itself and the address it was fetched from. Decoding them once and
+// caching the result saves fetch from picking apart IR on every cycle.
+type decodedOp struct {
+	// Set once the entry has been filled in, cleared when the word is written
+	valid bool
+
+	// Operation code (IR bits 0-2)
+	op uint16
+
+	// Direct effective address for memory reference instructions, with the
+	// page bit already resolved against the address of the instruction
+	addr uint16
+
+	// Indirect bit set
+	indirect bool
+
+	// Indirect through one of the auto-index registers (0o10-0o17)
+	auto bool
+}
+
+// Returns the decoded instruction at the 15-bit address addr of the memory
+// instructions are fetched from, decoding and caching it if the table entry
+// isn't valid.
+func (mk *MK12) decode(addr uint16) *decodedOp {
+	table := &mk.decoded
+	mem := mk.MEM
+	if mk.PANEL.CTRL {
+		table = &mk.decodedPanel
+		mem = mk.PMEM
+	}
+	if *table == nil {
+		*table = make([]decodedOp, len(mem))
+	}
+	d := &(*table)[addr]
+	if d.valid && !mk.noDecodeCache {
+		return d
+	}
+
+	ir := mem[addr]
+	d.op = ir >> 9
+	d.addr = 0
+	d.indirect = false
+	d.auto = false
+	if d.op <= JMP {
+		if (ir & 0b0000000010000000) > 0 {
+			// If page bit is set, we use the current page
+			d.addr = addr & 0b0000111110000000
+		}
+		// Fill in word address in page
+		d.addr |= ir & 0b0000000001111111
+
+		if (ir & 0b0000000100000000) > 0 {
+			d.indirect = true
+			d.auto = (d.addr >= AUTO_begin) && (d.addr <= AUTO_end)
+		}
+	}
+	d.valid = true
+	return d
+}
+
+// Writes data to main memory at the 15-bit address addr. All stores to MEM
+// should go through here so the decoded instruction cache stays coherent.
+func (mk *MK12) write(addr uint16, data uint16) {
+	mk.MEM[addr] = data
+	if mk.decoded != nil {
+		mk.decoded[addr].valid = false
+	}
+}
+
+// Writes data to panel memory if panel is set, otherwise to main memory
+func (mk *MK12) writeMem(panel bool, addr uint16, data uint16) {
+	if !panel {
+		mk.write(addr, data)
+		return
+	}
+	mk.PMEM[addr] = data
+	if mk.decodedPanel != nil {
+		mk.decodedPanel[addr].valid = false
+	}
+}
+
+// Throws away every decoded instruction. Call after replacing MEM wholesale.
+func (mk *MK12) invalidate() {
+	mk.decoded = nil
+	mk.decodedPanel = nil
+}
+
+// Returns the mnemonics for the micro-operations encoded in an OPR
+// instruction, e.g. "CLA CLL RAL". Only used to fill in IRd.
+func oprMnemonics(ir uint16) string {
+	var m []string
+	if (ir>>8)&1 == 0 {
+		// Group 1
+		if (ir>>7)&1 == 1 {
+			m = append(m, "CLA")
+		}
+		if (ir>>6)&1 == 1 {
+			m = append(m, "CLL")
+		}
+		if (ir>>5)&1 == 1 {
+			m = append(m, "CMA")
+		}
+		if (ir>>4)&1 == 1 {
+			m = append(m, "CML")
+		}
+		if ir&1 == 1 {
+			m = append(m, "IAC")
+		}
+		twice := (ir>>1)&1 == 1
+		if (ir>>3)&1 == 1 {
+			if twice {
+				m = append(m, "RTR")
+			} else {
+				m = append(m, "RAR")
+			}
+		}
+		if (ir>>2)&1 == 1 {
+			if twice {
+				m = append(m, "RTL")
+			} else {
+				m = append(m, "RAL")
+			}
+		}
+	} else if ir&1 == 0 {
+		// Group 2
+		if (ir>>7)&1 == 1 {
+			m = append(m, "CLA")
+		}
+		reverse := (ir>>3)&1 == 1
+		if (ir>>6)&1 == 1 {
+			if reverse {
+				m = append(m, "SPA")
+			} else {
+				m = append(m, "SMA")
+			}
+		}
+		if (ir>>5)&1 == 1 {
+			if reverse {
+				m = append(m, "SNA")
+			} else {
+				m = append(m, "SZA")
+			}
+		}
+		if (ir>>4)&1 == 1 {
+			if reverse {
+				m = append(m, "SZL")
+			} else {
+				m = append(m, "SNL")
+			}
+		}
+		if reverse && (ir>>4)&0o7 == 0 {
+			m = append(m, "SKP")
+		}
+		if (ir>>2)&1 == 1 {
+			m = append(m, "OSR")
+		}
+		if (ir>>1)&1 == 1 {
+			m = append(m, "HLT")
+		}
+	} else {
+		// Group 3
+		m = append(m, fmt.Sprintf("%04o", ir))
+	}
+	if len(m) == 0 {
+		return "NOP"
+	}
+	return strings.Join(m, " ")
+}
@@ -1,9 +1,6 @@
 package main
 
-import (
-	"io"
-	"os"
-)
+import "time"
 
 type Device interface {
 	// Select returns true if addr is addressed to this device, false otherwise.
@@ -25,6 +22,24 @@ type Device interface {
 	Iop4() (skip bool, clr bool, or bool)
 }
 
+// Devices that can request a program interrupt implement Interrupter.
+// InterruptRequest is polled between instructions while interrupts are on.
+type Interrupter interface {
+	InterruptRequest() bool
+}
+
+// Devices with flags that are cleared by CAF (and power up) implement Clearer.
+type Clearer interface {
+	ClearFlags()
+}
+
+// Devices that decode the whole function code of their IOTs, rather than
+// acting on the IOP1, IOP2 and IOP4 pulses in turn, implement IOTDecoder.
+// Operate is called instead of Iop1/2/4 with IR bits 9-11.
+type IOTDecoder interface {
+	Operate(fn uint16) (skip bool, clr bool, or bool)
+}
+
 const (
 	PT_READER   = 0o01
 	PT_PUNCH    = 0o02
@@ -32,6 +47,59 @@ const (
 	TT_PRINTER  = 0o04
 )
 
+// Device codes of the built-in devices other than the console, whether or
+// not they are installed, so devices added on the command line don't hide them
+var builtinDeviceCodes = map[uint16]string{
+	PT_READER:     "the paper tape reader",
+	PT_PUNCH:      "the paper tape punch",
+	VC_DEV:        "the VC8-E display",
+	CLK_DEV:       "the real-time clock",
+	PL_DEV_PEN:    "the plotter",
+	PL_DEV_RIGHT:  "the plotter",
+	PL_DEV_LEFT:   "the plotter",
+	DF_DEV_MA:     "the DF32/RF08 disk",
+	DF_DEV_EA:     "the DF32/RF08 disk",
+	DF_DEV_STA:    "the DF32/RF08 disk",
+	RF_DEV_EA:     "the RF08 disk",
+	CR_DEV_DATA:   "the card reader",
+	LP_DEV:        "the line printer",
+	CR_DEV_STATUS: "the card reader",
+	RK_DEV:        "the RK8-E disk",
+	RX_DEV:        "the RX8E floppy disk",
+	DT_DEV_A:      "the TC08 DECtape",
+	DT_DEV_B:      "the TC08 DECtape",
+}
+
+// Device codes taken by the built-in devices, the console, the serial lines
+// and the DR8-Es attached so far, and what takes them
+func (mk *MK12) usedDeviceCodes() map[uint16]string {
+	used := map[uint16]string{TT_KEYBOARD: "the console teletype", TT_PRINTER: "the console teletype"}
+	for c, name := range builtinDeviceCodes {
+		used[c] = name
+	}
+	for _, dev := range mk.IOT {
+		switch d := dev.(type) {
+		case *TeleTypeDevice:
+			if d.KeyboardCode != 0 && d.KeyboardCode != TT_KEYBOARD {
+				used[d.KeyboardCode], used[d.PrinterCode] = "a serial line", "a serial line"
+			}
+		case *DR8Device:
+			used[d.Code] = "a DR8-E"
+		}
+	}
+	return used
+}
+
+// How often a printer that isn't ready is checked again, if characters take
+// less time than this to print
+const TT_retry = time.Millisecond
+
+// Teletype personalities
+const (
+	TT_MODERN = iota // Characters are passed through unchanged
+	TT_ASR33         // Behaves like a Model 33 ASR, see asr33.go
+)
+
 /////////////////////
 // TeleType Device
 //
@@ -47,6 +115,29 @@ type TeleTypePrinter interface {
 	Available() int
 }
 
+// KL8-E keyboard function codes
+const (
+	KCF = 0o0 // Clear keyboard flag
+	KSF = 0o1 // Skip if keyboard flag
+	KCC = 0o2 // Clear keyboard flag and AC
+	KRS = 0o4 // Read keyboard buffer static (OR into AC)
+	KIE = 0o5 // Set interrupt enable from AC bit 11
+	KRB = 0o6 // Clear AC, read keyboard buffer, clear keyboard flag
+)
+
+// KL8-E printer function codes
+const (
+	TFL = 0o0 // Set printer flag (SPF)
+	TSF = 0o1 // Skip if printer flag
+	TCF = 0o2 // Clear printer flag
+	TPC = 0o4 // Print AC bits 4-11
+	SPI = 0o5 // Skip if keyboard or printer interrupt requested
+	TLS = 0o6 // Clear printer flag and print AC bits 4-11
+)
+
+// TeleTypeDevice is a KL8-E asynchronous serial line interface: a keyboard
+// (receiver) and printer (transmitter) at consecutive device codes. The
+// console is at 03/04, more lines can be added at other codes.
 type TeleTypeDevice struct {
 	Device
 	In  int // Last teletype input char  (keyboard)
@@ -55,161 +146,194 @@ type TeleTypeDevice struct {
 	Keyboard TeleTypeKeyboard
 	Printer  TeleTypePrinter
 
+	// Device codes of the keyboard and printer, TT_KEYBOARD and TT_PRINTER
+	// if not set
+	KeyboardCode uint16
+	PrinterCode  uint16
+
+	// Keyboard and printer flags
+	KF bool
+	PF bool
+
+	// Interrupt enable, set on power up and by CAF
+	IE bool
+
+	// Keyboard buffer
+	RB uint16
+
+	// Time taken to print a character, the printer flag is set this long
+	// after TPC or TLS. With none it is set once the instruction is done.
+	CharTime time.Duration
+
+	// How the line behaves, TT_MODERN or TT_ASR33
+	Personality int
+
+	// ASR-33 carriage column and when the next character can be typed, in
+	// simulated time
+	col     int
+	nextKey time.Duration
+
+	// Set while a character is being printed
+	busy bool
+
+	mk  *MK12  // Machine the line is attached to, for printer completion
 	ac  uint16 // Local copy of AC
-	dev uint   // Device currently being interfaced with (Keyboard or printer)
+	dev uint16 // Device currently being interfaced with (Keyboard or printer)
+}
+
+// Creates a line with the keyboard at code and the printer at code+1
+func NewTeleTypeDevice(code uint16, kb TeleTypeKeyboard, p TeleTypePrinter) *TeleTypeDevice {
+	return &TeleTypeDevice{
+		Keyboard:     kb,
+		Printer:      p,
+		KeyboardCode: code,
+		PrinterCode:  code + 1,
+		IE:           true,
+	}
 }
 
 func (tt *TeleTypeDevice) Select(addr uint16, mk *MK12) bool {
+	if tt.KeyboardCode == 0 {
+		tt.KeyboardCode, tt.PrinterCode = TT_KEYBOARD, TT_PRINTER
+	}
 
-	if addr == TT_KEYBOARD || addr == TT_PRINTER {
-		if addr == TT_PRINTER {
-			tt.dev = TT_PRINTER
-			// Save AC in case we need to print it
-			tt.ac = mk.AC
-		} else {
-			tt.dev = TT_KEYBOARD
-		}
-		return true
+	switch addr {
+	case tt.KeyboardCode:
+		tt.dev = TT_KEYBOARD
+	case tt.PrinterCode:
+		tt.dev = TT_PRINTER
+	default:
+		return false
 	}
-	return false
+	// Save AC in case we need to print it or set the interrupt enable
+	tt.ac = mk.AC
+	tt.mk = mk
+	return true
 }
 
 func (tt *TeleTypeDevice) Get() (data uint16) {
-	cData, err := tt.Keyboard.ReadByte()
+	return tt.RB
+}
+
+// Reads a character into the keyboard buffer if one has been typed. Keys
+// wait in the keyboard until the program has taken the last one.
+func (tt *TeleTypeDevice) poll() {
+	if tt.KF || tt.Keyboard.Buffered() == 0 {
+		return
+	}
+	if tt.Personality == TT_ASR33 && tt.mk != nil && tt.mk.HW.TIME < tt.nextKey {
+		return
+	}
+	c, err := tt.Keyboard.ReadByte()
 	if err != nil {
 		panic("Read error")
 	}
-	return uint16(cData)
-}
-
-func (tt *TeleTypeDevice) Iop1() (skip bool, clr bool, or bool) {
-	if tt.dev == TT_KEYBOARD {
-		if tt.Keyboard.Buffered() > 0 { // KSF - Skip if incoming data available
-			skip = true
-		}
-	} else if tt.dev == TT_PRINTER {
-		if tt.Printer.Available() > 0 { // TSF - Skip if available to send
-			skip = true
+	if tt.Personality == TT_ASR33 {
+		c = asr33Key(c)
+		if tt.mk != nil {
+			tt.nextKey = tt.mk.HW.TIME + ASR33_charTime
 		}
 	}
-	return skip, clr, or
+	tt.RB = uint16(c)
+	tt.In = int(c)
+	tt.KF = true
 }
 
-func (tt *TeleTypeDevice) Iop2() (skip bool, clr bool, or bool) {
-	if tt.dev == TT_KEYBOARD { // KCC
-		// Clear internal indicator of character ready (will be done when we read the byte)
-		clr = true // Signal to clear AC in preparation of data exchange
+// Prints the character saved from AC, the printer flag is set once it is done
+func (tt *TeleTypeDevice) print() {
+	c := byte(tt.ac & 0o377)
+	if tt.Personality == TT_ASR33 {
+		tt.asr33Print(c)
+	} else {
+		tt.Printer.WriteByte(c)
 	}
-	return skip, clr, or
+	tt.Printer.Flush()
+	tt.Out = int(c)
+	tt.busy = true
+	tt.mk.schedule(tt.charTime(), tt.printed)
 }
 
-func (tt *TeleTypeDevice) Iop4() (skip bool, clr bool, or bool) {
-	if tt.dev == TT_KEYBOARD { // KRS - Read keyboard buffer
-		or = true
-	} else if tt.dev == TT_PRINTER { // TPC - Print the contents of AC 4-11
-		tt.Printer.WriteByte(byte(tt.ac & 0b000011111111))
-		tt.Printer.Flush()
+// Time taken to print a character, at least 100ms on an ASR-33
+func (tt *TeleTypeDevice) charTime() time.Duration {
+	if tt.Personality == TT_ASR33 && tt.CharTime < ASR33_charTime {
+		return ASR33_charTime
 	}
-	return skip, clr, or
-}
-
-///////////////////////////////////
-// Paper Tape Reader/Punch Device (PC8-E)
-//
-
-type PaperTapeDevice struct {
-	Device
-
-	inTape  *os.File
-	outTape *os.File
-
-	// Read Buffer - Register to hold the character read from the tape
-	RB uint16
-	// Reader Flag - Flag to signify if character is available to read
-	RF bool
-
-	// Punch buffer - register to hold the character to punch
-	PB uint16
-	// Punch Flag - Denote a punch operation is complete
-	PF bool
-
-	// Device currently being interfaced with
-	dev int
-	// Local copy of AC
-	ac uint16
+	return tt.CharTime
 }
 
-func (pt *PaperTapeDevice) Select(addr uint16, mk *MK12) bool {
-	if addr == PT_READER {
-		pt.dev = PT_READER
-		return true
-	} else if addr == PT_PUNCH {
-		pt.dev = PT_PUNCH
-		// Flag starts true
-		pt.PF = true
-		// Save AC for use in PPC instruction
-		pt.ac = mk.AC
-		return true
+// Printer completion. A printer that isn't ready yet (like a telnet line
+// nobody is connected to) holds the flag off until it is.
+func (tt *TeleTypeDevice) printed() {
+	if !tt.busy {
+		return
 	}
-	return false
-}
-
-func (pt *PaperTapeDevice) Get() (data uint16) {
-	return pt.RB
+	if tt.Printer.Available() == 0 {
+		retry := tt.charTime()
+		if retry < TT_retry {
+			retry = TT_retry
+		}
+		tt.mk.schedule(retry, tt.printed)
+		return
+	}
+	tt.busy = false
+	tt.PF = true
 }
 
-func (pt *PaperTapeDevice) Iop1() (skip bool, clr bool, or bool) {
-	if pt.dev == PT_READER {
-		skip = pt.RF
+func (tt *TeleTypeDevice) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	if tt.dev == TT_KEYBOARD {
+		switch fn {
+		case KCF:
+			tt.KF = false
+		case KIE:
+			tt.IE = tt.ac&1 != 0
+		default:
+			if fn&KSF != 0 {
+				tt.poll()
+				skip = tt.KF
+			}
+			if fn&KCC != 0 {
+				clr = true
+			}
+			or = fn&KRS != 0
+			if fn&KCC != 0 {
+				tt.KF = false
+			}
+		}
+		return
 	}
-	if pt.dev == PT_PUNCH {
-		skip = pt.PF
+
+	switch fn {
+	case TFL:
+		tt.PF = true
+	case SPI:
+		skip = tt.InterruptRequest()
+	default:
+		if fn&TSF != 0 {
+			skip = tt.PF
+		}
+		if fn&TCF != 0 {
+			tt.PF = false
+		}
+		if fn&TPC != 0 {
+			tt.print()
+		}
 	}
 	return
 }
 
-func (pt *PaperTapeDevice) Iop2() (skip bool, clr bool, or bool) {
-	if pt.dev == PT_READER {
-		or = true
-	}
-	if pt.dev == PT_PUNCH {
-		// Clear punch flag
-		pt.PF = false
-		// Clear PB?
-		// pt.PB = 0
+func (tt *TeleTypeDevice) InterruptRequest() bool {
+	if !tt.IE {
+		return false
 	}
-	return
+	tt.poll()
+	return tt.KF || tt.PF
 }
 
-func (pt *PaperTapeDevice) Iop4() (skip bool, clr bool, or bool) {
-	if pt.dev == PT_READER {
-		// Clear reader flag
-		pt.RF = false
-		// Read character(byte) into RB
-		nextByte := make([]byte, 1)
-		_, err := pt.inTape.Read(nextByte)
-		if err != nil {
-			if err == io.EOF {
-				// End of file
-				pt.RB = 0
-			} else {
-				println("papertape jam")
-				panic(err)
-			}
-		} else {
-			pt.RB = uint16(nextByte[0])
-		}
-		// Set reader flag
-		pt.RF = true
-	}
-	if pt.dev == PT_PUNCH {
-		// Punch character
-		_, err := pt.outTape.Write([]byte{byte(pt.ac)})
-		if err != nil {
-			panic(err)
-		}
-		// Set Punch flag
-		pt.PF = true
-	}
-	return
+// CAF clears both flags, a character being printed no longer sets the
+// printer flag when it is done
+func (tt *TeleTypeDevice) ClearFlags() {
+	tt.KF = false
+	tt.PF = false
+	tt.busy = false
+	tt.IE = true
 }
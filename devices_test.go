@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// An ASR-33 prints and reads a character every 100ms of simulated time
+func TestASR33Pacing(t *testing.T) {
+	mk := newTestMK12(t)
+	var printed bytes.Buffer
+	keys := bufio.NewReader(strings.NewReader("AB"))
+	keys.Peek(2) // Both keys have been typed
+	tt := NewTeleTypeDevice(TT_KEYBOARD, keys, bufio.NewWriter(&printed))
+	tt.Personality = TT_ASR33
+
+	testIOT(mk, tt, TT_PRINTER, TLS, 'X')
+	start := mk.HW.TIME
+	waitTest(t, mk, time.Second, func() bool { return testIOT(mk, tt, TT_PRINTER, TSF, 0) })
+	if d := mk.HW.TIME - start; d < ASR33_charTime || d > ASR33_charTime+time.Millisecond {
+		t.Errorf("printer flag set after %v", d)
+	}
+	if printed.String() != "X" {
+		t.Errorf("printed %q", printed.String())
+	}
+
+	start = mk.HW.TIME
+	for _, want := range []uint16{'A' | 0o200, 'B' | 0o200} {
+		waitTest(t, mk, time.Second, func() bool { return testIOT(mk, tt, TT_KEYBOARD, KSF, 0) })
+		testIOT(mk, tt, TT_KEYBOARD, KRB, 0)
+		if mk.AC != want {
+			t.Errorf("read %04o, want %04o", mk.AC, want)
+		}
+	}
+	if d := mk.HW.TIME - start; d < ASR33_charTime || d > ASR33_charTime+time.Millisecond {
+		t.Errorf("second key read after %v", d)
+	}
+}
+
+// A diagonal run into the top edge of the paper goes on along it, the line
+// bends where the drum stopped
+func TestPlotterEdge(t *testing.T) {
+	mk := newTestMK12(t)
+	pl := &Plotter{mk: mk}
+	top := pl.steps(plotterHeight)
+	pl.Y = top - 5
+	pl.pen(true)
+	for i := 0; i < 6; i++ {
+		pl.step(1, 1)
+	}
+	want := [][2]int{{0, top - 5}, {5, top}, {6, top}}
+	if len(pl.lines) != 1 || fmt.Sprint(pl.lines[0]) != fmt.Sprint(want) {
+		t.Errorf("drew %v, want %v", pl.lines, want)
+	}
+}
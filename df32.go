@@ -0,0 +1,527 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// DF32 and RF08 device codes, the RF08 also uses 64
+const (
+	DF_DEV_MA  = 0o60 // Disk address and starting transfers
+	DF_DEV_EA  = 0o61 // Extended address (DF32), interrupt enables and status (RF08)
+	DF_DEV_STA = 0o62 // Flags and reading the disk address
+	RF_DEV_EA  = 0o64 // RF08 extended address
+)
+
+// Function codes, device 60
+const (
+	DCMA = 0o1 // Clear the disk address and the flags
+	DMAR = 0o3 // DCMA, load the disk address from AC and read, clear AC
+	DMAW = 0o5 // DCMA, load the disk address from AC and write, clear AC
+)
+
+// Function pulses, device 62
+const (
+	DFSE = 0o1 // Skip on no error
+	DFSC = 0o2 // Skip on completion
+	DMAC = 0o6 // Clear AC, read the disk address
+)
+
+// DF32 function codes, device 61
+const (
+	DCEA = 0o1 // Clear the disk and memory extensions
+	DSAC = 0o2 // Skip on address confirmed, clear AC
+	DEAL = 0o5 // DCEA, load the disk and memory extensions from AC
+	DEAC = 0o6 // Clear AC, read the extensions and photocell status
+)
+
+// RF08 function codes, device 61
+const (
+	DCIM = 0o1 // Clear the interrupt enables and memory extension
+	DIML = 0o5 // Load the interrupt enables and memory extension from AC, clear AC
+	DIMA = 0o6 // Clear AC, read the status register
+)
+
+// RF08 function codes, device 64
+const (
+	DCXA = 0o1 // Clear the disk extended address
+	DXAL = 0o3 // Load the disk extended address from AC, clear AC
+	DXAC = 0o5 // Clear AC, read the disk extended address
+	DMMT = 0o6 // Maintenance, not implemented
+)
+
+// DF32 status, read by DEAC
+const (
+	DF_STA_PCA = 0o4000 // Photocell
+	DF_STA_DEX = 0o3700 // Disk address extension
+	DF_STA_MEX = 0o0070 // Memory field
+	DF_STA_DRL = 0o0004 // Data late
+	DF_STA_WLS = 0o0002 // Write lock, or no such disk
+	DF_STA_PER = 0o0001 // Parity error
+)
+
+// RF08 status, read by DIMA
+const (
+	RF_STA_PCA = 0o4000 // Photocell
+	RF_STA_DRE = 0o2000 // Data request enable
+	RF_STA_WLS = 0o1000 // Write lock
+	RF_STA_EIE = 0o0400 // Interrupt on error
+	RF_STA_PIE = 0o0200 // Interrupt on photocell
+	RF_STA_CIE = 0o0100 // Interrupt on completion
+	RF_STA_MEX = 0o0070 // Memory field
+	RF_STA_DRL = 0o0004 // Data late
+	RF_STA_NXD = 0o0002 // No such disk
+	RF_STA_PER = 0o0001 // Parity error
+	RF_STA_IE  = RF_STA_EIE | RF_STA_PIE | RF_STA_CIE | RF_STA_MEX
+)
+
+// Word count and current address of the three cycle data break, in field 0
+const (
+	DF_WC = 0o7750
+	DF_CA = 0o7751
+)
+
+// Geometry. A DF32 disk (or DS32 expander) holds 32K words and an RS08 256K,
+// in tracks of 2048 words. Up to four disks can be attached to either.
+const (
+	DF_DRIVES   = 4
+	DF_TRACK    = 2048
+	DF_DS32     = 16 * DF_TRACK
+	DF_RS08     = 128 * DF_TRACK
+	DF_SWITCHES = 8 // Write lock switches on each disk, each protecting an eighth of it
+)
+
+// Time for a word to pass the heads, 1800 rpm
+const DF_wordTime = 16 * time.Microsecond
+
+// fixedHeadDisk is what the DF32 and RF08 have in common: fixed head disks
+// where any word can be read or written once it comes round, by three cycle
+// data break through the word count and current address at 7750 and 7751.
+// The photocell flag is set each time the start of the tracks passes.
+type fixedHeadDisk struct {
+	Device
+
+	DA  uint32 // Disk address
+	MEX uint16 // Memory field
+
+	// Completion and error flags
+	done      bool
+	writeLock bool
+	noDisk    bool
+	parity    bool
+
+	// Whether a transfer is waiting for its word to come round or under way,
+	// and a count of the transfers started, so an abandoned one stops
+	busy     bool
+	transfer int
+
+	// When the photocell flag was last cleared
+	pcaCleared time.Duration
+
+	size   int // Words on each disk
+	drives [DF_DRIVES]*diskImage
+
+	// Write lock switches for each disk
+	locks [DF_DRIVES]uint8
+
+	mk  *MK12
+	ac  uint16
+	dev uint16
+}
+
+func (fh *fixedHeadDisk) Drives() int {
+	return DF_DRIVES
+}
+
+func (fh *fixedHeadDisk) Attach(drive int, path string, readOnly bool) error {
+	img, err := openImage(path, readOnly)
+	if err != nil {
+		return err
+	}
+	fh.drives[drive] = img
+	return nil
+}
+
+func (fh *fixedHeadDisk) Detach(drive int) error {
+	if fh.drives[drive] == nil {
+		return fmt.Errorf("nothing is attached to drive %d", drive)
+	}
+	err := fh.drives[drive].Close()
+	fh.drives[drive] = nil
+	return err
+}
+
+func (fh *fixedHeadDisk) Image(drive int) *diskImage {
+	return fh.drives[drive]
+}
+
+// Write lock switches of a disk, bit 0 protecting its first eighth
+func (fh *fixedHeadDisk) WriteLock(drive int) uint8 {
+	return fh.locks[drive]
+}
+
+func (fh *fixedHeadDisk) SetWriteLock(drive int, switches uint8) {
+	fh.locks[drive] = switches
+}
+
+func (fh *fixedHeadDisk) selectDev(addr uint16, mk *MK12) {
+	fh.dev = addr
+	fh.mk = mk
+	fh.ac = mk.AC
+}
+
+func (fh *fixedHeadDisk) errors() bool {
+	return fh.writeLock || fh.noDisk || fh.parity
+}
+
+// Word of the track passing the heads
+func (fh *fixedHeadDisk) position() uint32 {
+	return uint32(fh.mk.HW.TIME/DF_wordTime) % DF_TRACK
+}
+
+// Whether the photocell has passed the start of the tracks since the flag
+// was cleared
+func (fh *fixedHeadDisk) photocell() bool {
+	rev := DF_wordTime * DF_TRACK
+	return fh.mk != nil && fh.mk.HW.TIME/rev > fh.pcaCleared/rev
+}
+
+// DCMA clears the low 12 bits of the disk address and the flags, stopping
+// any transfer
+func (fh *fixedHeadDisk) clear() {
+	fh.DA &^= 0o7777
+	fh.done, fh.writeLock, fh.noDisk, fh.parity = false, false, false, false
+	fh.busy = false
+	fh.transfer++
+	fh.pcaCleared = fh.mk.HW.TIME
+}
+
+// Function codes on device 60 and 62, which are the same on both
+func (fh *fixedHeadDisk) operate(fn uint16) (skip bool, clr bool, or bool) {
+	if fh.dev == DF_DEV_MA {
+		if fn&DCMA != 0 {
+			fh.clear()
+		}
+		if fn&(DMAR|DMAW)&^DCMA != 0 {
+			fh.DA |= uint32(fh.ac)
+			fh.start(fn == DMAW)
+			clr = true
+		}
+		return
+	}
+	if fn&DFSE != 0 && !fh.errors() {
+		skip = true
+	}
+	if fn&DMAC == DFSC && fh.done {
+		skip = true
+	}
+	if fn&DMAC == DMAC {
+		clr, or = true, true
+	}
+	return
+}
+
+// Starts a transfer once the disk address comes round under the heads
+func (fh *fixedHeadDisk) start(write bool) {
+	fh.busy = true
+	fh.transfer++
+	transfer := fh.transfer
+	words := (fh.DA%DF_TRACK + DF_TRACK - fh.position()) % DF_TRACK
+	fh.mk.schedule(time.Duration(words)*DF_wordTime, func() {
+		if transfer == fh.transfer {
+			fh.run(write, transfer)
+		}
+	})
+}
+
+// Moves words until the word count overflows, then sets the completion
+// flag once the last of them has passed the heads
+func (fh *fixedHeadDisk) run(write bool, transfer int) {
+	mk := fh.mk
+	n := 0
+	for {
+		drive, off := int(fh.DA)/fh.size, int64(fh.DA)%int64(fh.size)
+		if drive >= DF_DRIVES || fh.drives[drive] == nil {
+			fh.noDisk = true
+			break
+		}
+		img := fh.drives[drive]
+		if write && (img.ReadOnly || fh.locks[drive]>>(off*DF_SWITCHES/int64(fh.size))&1 != 0) {
+			fh.writeLock = true
+			break
+		}
+
+		addr, overflow := mk.threeCycle(DF_WC, fh.MEX, true)
+		buf := []uint16{0}
+		if write {
+			buf[0] = mk.breakOut(addr)
+			if err := img.WriteWords(off, buf); err != nil {
+				fh.parity = true
+				break
+			}
+		} else {
+			if err := img.ReadWords(off, buf); err != nil {
+				fh.parity = true
+				break
+			}
+			mk.breakIn(addr, buf[0])
+		}
+		fh.DA = (fh.DA + 1) % uint32(DF_DRIVES*fh.size)
+		n++
+		if overflow {
+			break
+		}
+	}
+	mk.schedule(time.Duration(n)*DF_wordTime, func() {
+		if transfer == fh.transfer {
+			fh.busy = false
+			fh.done = true
+		}
+	})
+}
+
+func (fh *fixedHeadDisk) reset() {
+	fh.DA, fh.MEX = 0, 0
+	fh.done, fh.writeLock, fh.noDisk, fh.parity = false, false, false, false
+	fh.busy = false
+	fh.transfer++
+}
+
+// DF32Device is a DF32 disk with up to three DS32 expanders, 32K words each.
+type DF32Device struct {
+	fixedHeadDisk
+}
+
+func NewDF32() Storage {
+	return &DF32Device{fixedHeadDisk{size: DF_DS32}}
+}
+
+// RF08Device is an RF08 controller with up to four RS08 disks of 256K words.
+// Interrupts on completion, error and the photocell are enabled separately.
+type RF08Device struct {
+	fixedHeadDisk
+
+	IE uint16 // Interrupt enables, in the status register layout
+}
+
+func NewRF08() Storage {
+	return &RF08Device{fixedHeadDisk: fixedHeadDisk{size: DF_RS08}}
+}
+
+func init() {
+	storageControllers["df"] = NewDF32
+	storageControllers["rf"] = NewRF08
+	storageConflicts["df"] = []string{"rf"}
+	storageConflicts["rf"] = []string{"df"}
+	loaders["df"] = Loader{
+		Help:   "DF32 bootstrap, reads 128 words of df0 into 6604 and starts at 6622",
+		Origin: 0o7750,
+		Start:  0o7750,
+		Words:  dfBoot,
+	}
+	loaders["rf"] = Loader{
+		Help:   "RF08 bootstrap, reads 128 words of rf0 into 6604 and starts at 6622",
+		Origin: 0o7750,
+		Start:  0o7750,
+		Words:  dfBoot,
+	}
+	debugCommands["wlock"] = DebugCommand{
+		Usage: "<drive> [switches]",
+		Help:  "Show or set the octal write lock switches of a df or rf disk",
+		Run:   cmdWriteLock,
+	}
+}
+
+// The OS/8 bootstrap for either disk. Its first two words are also the word
+// count and current address, so DMAR reads the first 128 words of the disk
+// into 6604-7003, which the boot code at 6622 is waiting in.
+var dfBoot = []uint16{
+	0o7600, // 7750       CLA CLL       / Word count -200
+	0o6603, // 7751       DMAR          / Current address 6603
+	0o6622, // 7752       DFSC
+	0o5352, // 7753       JMP .-1
+	0o5752, // 7754       JMP I 7752
+}
+
+func (df *DF32Device) DriveName() string {
+	return "df"
+}
+
+func (df *DF32Device) Select(addr uint16, mk *MK12) bool {
+	if addr < DF_DEV_MA || addr > DF_DEV_STA {
+		return false
+	}
+	df.selectDev(addr, mk)
+	return true
+}
+
+func (df *DF32Device) status() uint16 {
+	sta := uint16(df.DA>>12)<<6&DF_STA_DEX | df.MEX<<3
+	if df.photocell() {
+		sta |= DF_STA_PCA
+	}
+	if df.writeLock || df.noDisk {
+		sta |= DF_STA_WLS
+	}
+	if df.parity {
+		sta |= DF_STA_PER
+	}
+	return sta
+}
+
+func (df *DF32Device) Get() uint16 {
+	if df.dev == DF_DEV_EA {
+		return df.status()
+	}
+	return uint16(df.DA) & 0o7777
+}
+
+func (df *DF32Device) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	if df.dev != DF_DEV_EA {
+		return df.operate(fn)
+	}
+	switch fn {
+	case DCEA:
+		df.DA &= 0o7777
+		df.MEX = 0
+	case DSAC:
+		skip = df.busy && df.position() == df.DA%DF_TRACK
+		clr = true
+	case DEAL:
+		df.DA = uint32(df.ac&DF_STA_DEX)>>6<<12 | df.DA&0o7777
+		df.MEX = (df.ac & DF_STA_MEX) >> 3
+	case DEAC:
+		clr, or = true, true
+	}
+	return
+}
+
+// The DF32 interrupts on completion or an error
+func (df *DF32Device) InterruptRequest() bool {
+	return df.done || df.errors()
+}
+
+func (df *DF32Device) ClearFlags() {
+	df.reset()
+}
+
+func (rf *RF08Device) DriveName() string {
+	return "rf"
+}
+
+func (rf *RF08Device) Select(addr uint16, mk *MK12) bool {
+	if (addr < DF_DEV_MA || addr > DF_DEV_STA) && addr != RF_DEV_EA {
+		return false
+	}
+	rf.selectDev(addr, mk)
+	return true
+}
+
+func (rf *RF08Device) status() uint16 {
+	sta := rf.IE | rf.MEX<<3
+	if rf.photocell() {
+		sta |= RF_STA_PCA
+	}
+	if rf.writeLock {
+		sta |= RF_STA_WLS
+	}
+	if rf.noDisk {
+		sta |= RF_STA_NXD
+	}
+	if rf.parity {
+		sta |= RF_STA_PER
+	}
+	return sta
+}
+
+func (rf *RF08Device) Get() uint16 {
+	switch rf.dev {
+	case DF_DEV_EA:
+		return rf.status()
+	case RF_DEV_EA:
+		return uint16(rf.DA>>12) & 0o377
+	}
+	return uint16(rf.DA) & 0o7777
+}
+
+func (rf *RF08Device) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch rf.dev {
+	case DF_DEV_EA:
+		switch fn {
+		case DCIM:
+			rf.IE, rf.MEX = 0, 0
+		case DSAC:
+			skip = rf.busy && rf.position() == rf.DA%DF_TRACK
+		case DIML:
+			rf.IE = rf.ac & (RF_STA_IE &^ RF_STA_MEX)
+			rf.MEX = (rf.ac & RF_STA_MEX) >> 3
+			clr = true
+		case DIMA:
+			clr, or = true, true
+		}
+		return
+
+	case RF_DEV_EA:
+		switch fn {
+		case DCXA:
+			rf.DA &= 0o7777
+		case DXAL:
+			rf.DA = uint32(rf.ac&0o377)<<12 | rf.DA&0o7777
+			clr = true
+		case DXAC:
+			clr, or = true, true
+		}
+		return
+	}
+	return rf.operate(fn)
+}
+
+func (rf *RF08Device) InterruptRequest() bool {
+	return rf.IE&RF_STA_CIE != 0 && rf.done ||
+		rf.IE&RF_STA_EIE != 0 && rf.errors() ||
+		rf.IE&RF_STA_PIE != 0 && rf.photocell()
+}
+
+func (rf *RF08Device) ClearFlags() {
+	rf.reset()
+	rf.IE = 0
+}
+
+// Fixed head disks have write lock switches, each protecting an eighth of
+// a disk
+type writeLocker interface {
+	WriteLock(drive int) uint8
+	SetWriteLock(drive int, switches uint8)
+}
+
+func cmdWriteLock(mk *MK12, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", fmt.Errorf("usage: wlock <drive> [switches]")
+	}
+	name, unit, err := parseDrive(args[0])
+	if err != nil {
+		return "", err
+	}
+	for _, dev := range mk.IOT {
+		s, ok := dev.(Storage)
+		if !ok || s.DriveName() != name {
+			continue
+		}
+		wl, ok := dev.(writeLocker)
+		if !ok {
+			return "", fmt.Errorf("%s drives have no write lock switches", name)
+		}
+		if unit >= s.Drives() {
+			return "", fmt.Errorf("%s has drives %s0-%s%d", name, name, name, s.Drives()-1)
+		}
+		if len(args) == 2 {
+			switches, err := parseOctal(args[1], 0o377)
+			if err != nil {
+				return "", err
+			}
+			wl.SetWriteLock(unit, uint8(switches))
+		}
+		return fmt.Sprintf("%s%d write lock %03o", name, unit, wl.WriteLock(unit)), nil
+	}
+	return "", fmt.Errorf("no %s controller is installed", name)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DR8-E function codes
+const (
+	DBLO = 0o0 // Load the outputs from AC and clear AC
+	DBSK = 0o1 // Skip on the input flag
+	DBCF = 0o2 // Clear the input flag
+	DBRI = 0o3 // Read the inputs into AC
+	DBSO = 0o4 // Set the outputs that are set in AC
+	DBCO = 0o5 // Clear the outputs that are set in AC
+	DBLE = 0o6 // Load the interrupt enable from AC bit 11
+	DBRO = 0o7 // Read the outputs into AC
+)
+
+// How often input changes from the backend are picked up, in simulated time
+const DR8_pollTime = 100 * time.Microsecond
+
+// A change of the inputs, at a simulated time or, if At is 0, as soon as it
+// is picked up
+type DR8Input struct {
+	At    time.Duration
+	Value uint16
+}
+
+// DR8Backend connects the inputs and outputs of a DR8-E to something outside
+// the simulator.
+type DR8Backend interface {
+	// Called once before the program runs. The backend calls input, from any
+	// goroutine, to change the inputs.
+	Start(input func(DR8Input)) error
+
+	// Called whenever the program changes the outputs, at simulated time t
+	Output(t time.Duration, value uint16)
+
+	Close() error
+}
+
+// Creates a DR8-E backend by name from the argument after the colon. Go code
+// that drives the DR8-E adds its backend here, usually as DR8Funcs.
+var dr8Backends = map[string]func(arg string) (DR8Backend, error){}
+
+// DR8Funcs is a DR8Backend made of Go functions, either of which may be nil
+type DR8Funcs struct {
+	Inputs  func(input func(DR8Input)) error
+	Outputs func(t time.Duration, value uint16)
+}
+
+func (f *DR8Funcs) Start(input func(DR8Input)) error {
+	if f.Inputs == nil {
+		return nil
+	}
+	return f.Inputs(input)
+}
+
+func (f *DR8Funcs) Output(t time.Duration, value uint16) {
+	if f.Outputs != nil {
+		f.Outputs(t, value)
+	}
+}
+
+func (f *DR8Funcs) Close() error {
+	return nil
+}
+
+func init() {
+	dr8Backends["file"] = newDR8File
+	// Wires the outputs back to the inputs
+	dr8Backends["loop"] = func(arg string) (DR8Backend, error) {
+		var input func(DR8Input)
+		return &DR8Funcs{
+			Inputs:  func(in func(DR8Input)) error { input = in; return nil },
+			Outputs: func(t time.Duration, value uint16) { input(DR8Input{Value: value}) },
+		}, nil
+	}
+}
+
+// DR8Device is a DR8-E with twelve inputs and twelve outputs. The flag is set
+// whenever the inputs change, and interrupts if the interrupt enable is set.
+type DR8Device struct {
+	Device
+
+	Code uint16
+	In   uint16
+	Out  uint16
+	Flag bool
+	IE   bool
+
+	backend DR8Backend
+
+	// Input changes from the backend not yet picked up
+	mu      sync.Mutex
+	pending []DR8Input
+
+	mk   *MK12
+	ac   uint16
+	data uint16 // Read into AC
+}
+
+// Creates a DR8-E at a device code and starts its backend
+func NewDR8(mk *MK12, code uint16, backend DR8Backend) (*DR8Device, error) {
+	dr := &DR8Device{Code: code, backend: backend, mk: mk}
+	if err := backend.Start(dr.input); err != nil {
+		return nil, err
+	}
+	mk.schedule(0, dr.poll)
+	return dr, nil
+}
+
+func (dr *DR8Device) input(in DR8Input) {
+	dr.mu.Lock()
+	dr.pending = append(dr.pending, in)
+	dr.mu.Unlock()
+}
+
+// Picks up input changes from the backend
+func (dr *DR8Device) poll() {
+	dr.mu.Lock()
+	pending := dr.pending
+	dr.pending = nil
+	dr.mu.Unlock()
+	for _, in := range pending {
+		var delay time.Duration
+		if in.At > dr.mk.HW.TIME {
+			delay = in.At - dr.mk.HW.TIME
+		}
+		value := in.Value & 0o7777
+		dr.mk.schedule(delay, func() {
+			if value != dr.In {
+				dr.In = value
+				dr.Flag = true
+			}
+		})
+	}
+	dr.mk.schedule(DR8_pollTime, dr.poll)
+}
+
+func (dr *DR8Device) Select(addr uint16, mk *MK12) bool {
+	if addr != dr.Code {
+		return false
+	}
+	dr.ac = mk.AC
+	return true
+}
+
+func (dr *DR8Device) Get() uint16 {
+	return dr.data
+}
+
+func (dr *DR8Device) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch fn {
+	case DBLO:
+		dr.output(dr.ac)
+		clr = true
+	case DBSK:
+		skip = dr.Flag
+	case DBCF:
+		dr.Flag = false
+	case DBRI:
+		dr.data = dr.In
+		clr, or = true, true
+	case DBSO:
+		dr.output(dr.Out | dr.ac)
+	case DBCO:
+		dr.output(dr.Out &^ dr.ac)
+	case DBLE:
+		dr.IE = dr.ac&1 != 0
+	case DBRO:
+		dr.data = dr.Out
+		clr, or = true, true
+	}
+	return
+}
+
+// Changes the outputs, telling the backend
+func (dr *DR8Device) output(value uint16) {
+	if value == dr.Out {
+		return
+	}
+	dr.Out = value
+	dr.backend.Output(dr.mk.HW.TIME, value)
+}
+
+func (dr *DR8Device) InterruptRequest() bool {
+	return dr.IE && dr.Flag
+}
+
+// CAF clears the flag and the interrupt enable, the outputs stay as they are
+func (dr *DR8Device) ClearFlags() {
+	dr.Flag = false
+	dr.IE = false
+}
+
+// dr8File reads input changes from a file and writes output changes to
+// another, either of which may be a FIFO. Each change is a line with an
+// optional simulated time and an octal value, "15ms 0017" for example; input
+// changes without a time happen as soon as they are read. A FIFO is read and
+// written as the simulator runs, a file is read before it starts.
+type dr8File struct {
+	in  string
+	out *bufio.Writer
+	f   *os.File
+
+	// Output lines for a FIFO, written by a goroutine so the simulator
+	// doesn't wait for the other end. When the other end falls behind the
+	// oldest lines are dropped, so the latest value always gets through.
+	lines chan string
+	done  chan bool
+}
+
+func newDR8File(arg string) (DR8Backend, error) {
+	in, out, _ := strings.Cut(arg, ",")
+	d := &dr8File{in: in}
+	if out == "" {
+		return d, nil
+	}
+	if isFIFO(out) {
+		d.lines = make(chan string, 256)
+		d.done = make(chan bool)
+		go d.writeFIFO(out)
+		return d, nil
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return nil, err
+	}
+	d.f = f
+	d.out = bufio.NewWriter(f)
+	return d, nil
+}
+
+func isFIFO(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&os.ModeNamedPipe != 0
+}
+
+func (d *dr8File) Start(input func(DR8Input)) error {
+	if d.in == "" {
+		return nil
+	}
+	if isFIFO(d.in) {
+		// Opening a FIFO waits for the other end
+		go func() {
+			if f, err := os.Open(d.in); err == nil {
+				readDR8Inputs(d.in, f, input)
+				f.Close()
+			}
+		}()
+		return nil
+	}
+	f, err := os.Open(d.in)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readDR8Inputs(d.in, f, input)
+}
+
+// Reads input changes a line at a time, stopping at the first bad one
+func readDR8Inputs(path string, r io.Reader, input func(DR8Input)) error {
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var in DR8Input
+		var err error
+		if len(fields) == 2 {
+			in.At, err = time.ParseDuration(fields[0])
+			fields = fields[1:]
+		}
+		if err == nil && len(fields) == 1 {
+			var v uint64
+			v, err = strconv.ParseUint(fields[0], 8, 12)
+			in.Value = uint16(v)
+		}
+		if err != nil || len(fields) != 1 {
+			return fmt.Errorf("%s line %d: not [time] octal value", path, n)
+		}
+		input(in)
+	}
+	return s.Err()
+}
+
+func (d *dr8File) Output(t time.Duration, value uint16) {
+	line := fmt.Sprintf("%v %04o\n", t, value)
+	if d.lines != nil {
+		for {
+			select {
+			case d.lines <- line:
+				return
+			default:
+			}
+			// Full, make room by dropping the oldest line
+			select {
+			case <-d.lines:
+			default:
+			}
+		}
+	} else if d.out != nil {
+		d.out.WriteString(line)
+	}
+}
+
+func (d *dr8File) writeFIFO(path string) {
+	// Opening it read write doesn't wait for a reader
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	for line := range d.lines {
+		if err == nil {
+			_, err = f.WriteString(line)
+		}
+	}
+	if f != nil {
+		f.Close()
+	}
+	close(d.done)
+}
+
+func (d *dr8File) Close() error {
+	if d.lines != nil {
+		close(d.lines)
+		// Give the other end a moment to read what is left
+		select {
+		case <-d.done:
+		case <-time.After(time.Second):
+		}
+	}
+	if d.out == nil {
+		return nil
+	}
+	err := d.out.Flush()
+	if cerr := d.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Adds the DR8-Es given with -dr8 as code=backend to the machine. The serial
+// lines have to be attached first, their codes can't be taken.
+func (mk *MK12) attachDR8s(specs []string) error {
+	used := mk.usedDeviceCodes()
+	for _, spec := range specs {
+		code, backend, ok := strings.Cut(spec, "=")
+		if !ok || backend == "" {
+			return fmt.Errorf("DR8-E %q is not code=backend", spec)
+		}
+		n, err := strconv.ParseUint(code, 8, 6)
+		if err != nil || n < 0o01 || n > 0o76 || n == CPU_IOT || (n >= MEM_IOT_begin && n <= MEM_IOT_end) {
+			return fmt.Errorf("DR8-E device code %q is not octal 01-76 or is used by the processor", code)
+		}
+		if name, ok := used[uint16(n)]; ok {
+			return fmt.Errorf("DR8-E device code %02o is used by %s", n, name)
+		}
+		used[uint16(n)] = "a DR8-E"
+		name, arg, _ := strings.Cut(backend, ":")
+		newBackend, ok := dr8Backends[name]
+		if !ok {
+			return fmt.Errorf("unknown DR8-E backend %q", backend)
+		}
+		b, err := newBackend(arg)
+		if err != nil {
+			return err
+		}
+		dr, err := NewDR8(mk, uint16(n), b)
+		if err != nil {
+			b.Close()
+			return err
+		}
+		mk.IOT = append(mk.IOT, dr)
+	}
+	return nil
+}
+
+// Closes the DR8-E backends before the simulator exits
+func (mk *MK12) closeDR8s() {
+	for _, dev := range mk.IOT {
+		if dr, ok := dev.(*DR8Device); ok {
+			dr.backend.Close()
+		}
+	}
+}
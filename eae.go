@@ -0,0 +1,157 @@
+package main
+
+import "strings"
+
+// Group 3 operate microinstruction bits
+const (
+	G3_CLA = 0o200 // Clear AC
+	G3_MQA = 0o100 // OR MQ into AC
+	G3_SCA = 0o040 // OR step counter into AC (EAE)
+	G3_MQL = 0o020 // Load MQ from AC, clear AC
+)
+
+// EAE mode A operation codes (IR bits 8-10)
+const (
+	EAE_NOP = iota
+	EAE_SCL // Step counter load from memory
+	EAE_MUY // Multiply
+	EAE_DVI // Divide
+	EAE_NMI // Normalize
+	EAE_SHL // Shift left
+	EAE_ASR // Arithmetic shift right
+	EAE_LSR // Logical shift right
+)
+
+// Group 3 operate microinstructions, the MQ register and the extended
+// arithmetic element (EAE mode A). Sequenced as:
+//  1. CLA
+//  2. MQA SCA MQL (MQA MQL together swap AC and MQ)
+//  3. EAE operation
+//
+// Models without an MQ only execute CLA, the EAE operations need -eae.
+func (mk *MK12) group3() {
+	model := &mk.HW.MODEL
+
+	if mk.IR&G3_CLA != 0 {
+		mk.AC = 0
+	}
+
+	if !model.MQ && !mk.HW.EAE {
+		return
+	}
+
+	switch mk.IR & (G3_MQA | G3_MQL) {
+	case G3_MQA:
+		mk.AC |= mk.MQ
+	case G3_MQL:
+		mk.MQ = mk.AC
+		mk.AC = 0
+	case G3_MQA | G3_MQL: // SWP
+		mk.AC, mk.MQ = mk.MQ, mk.AC
+	}
+
+	if !mk.HW.EAE {
+		return
+	}
+
+	if mk.IR&G3_SCA != 0 {
+		mk.AC |= mk.SC
+	}
+
+	switch (mk.IR >> 1) & 0o7 {
+	case EAE_SCL:
+		mk.SC = ^mk.operand() & 0o37
+
+	case EAE_MUY:
+		p := uint32(mk.MQ)*uint32(mk.operand()) + uint32(mk.AC)
+		mk.AC = uint16(p>>12) & 0o7777
+		mk.MQ = uint16(p) & 0o7777
+		mk.L = false
+		mk.cycles += 6
+
+	case EAE_DVI:
+		n := mk.operand()
+		if mk.AC >= n {
+			// Divide overflow, AC and MQ are left alone
+			mk.L = true
+		} else {
+			d := uint32(mk.AC)<<12 | uint32(mk.MQ)
+			mk.MQ = uint16(d / uint32(n))
+			mk.AC = uint16(d % uint32(n))
+			mk.L = false
+		}
+		mk.cycles += 6
+
+	case EAE_NMI:
+		mk.SC = 0
+		for (mk.AC != 0 || mk.MQ != 0) && (mk.AC>>11)&1 == (mk.AC>>10)&1 && mk.SC < 24 {
+			mk.shiftLeft()
+			mk.SC++
+		}
+		mk.SC &= 0o37
+		mk.cycles += 1 + int(mk.SC)/4
+
+	case EAE_SHL:
+		n := mk.operand()&0o37 + 1
+		for i := uint16(0); i < n; i++ {
+			mk.shiftLeft()
+		}
+		mk.SC = 0
+		mk.cycles += 1 + int(n)/4
+
+	case EAE_ASR, EAE_LSR:
+		n := mk.operand()&0o37 + 1
+		arithmetic := (mk.IR>>1)&0o7 == EAE_ASR
+		sign := mk.AC & 0o4000
+		if !arithmetic {
+			sign = 0
+		}
+		for i := uint16(0); i < n; i++ {
+			mk.MQ = (mk.MQ >> 1) | ((mk.AC & 1) << 11)
+			mk.AC = (mk.AC >> 1) | sign
+		}
+		mk.L = sign != 0
+		mk.SC = 0
+		mk.cycles += 1 + int(n)/4
+	}
+}
+
+// Shifts L, AC and MQ left one place as a single register
+func (mk *MK12) shiftLeft() {
+	mk.L = (mk.AC>>11)&1 == 1
+	mk.AC = ((mk.AC << 1) | (mk.MQ >> 11)) & 0o7777
+	mk.MQ = (mk.MQ << 1) & 0o7777
+}
+
+// Reads the operand word following an EAE instruction and skips over it
+func (mk *MK12) operand() uint16 {
+	n := mk.imem()[mk.IF<<12|mk.PC]
+	mk.PC = (mk.PC + 1) % 4096
+	mk.cycles++
+	return n
+}
+
+// Returns the mnemonics of a group 3 instruction. Only used to fill in IRd.
+func eaeMnemonics(ir uint16) string {
+	var m []string
+	if ir&G3_CLA != 0 {
+		m = append(m, "CLA")
+	}
+	switch ir & (G3_MQA | G3_MQL) {
+	case G3_MQA:
+		m = append(m, "MQA")
+	case G3_MQL:
+		m = append(m, "MQL")
+	case G3_MQA | G3_MQL:
+		m = append(m, "SWP")
+	}
+	if ir&G3_SCA != 0 {
+		m = append(m, "SCA")
+	}
+	m = append(m, [...]string{"", "SCL", "MUY", "DVI", "NMI", "SHL", "ASR", "LSR"}[(ir>>1)&0o7])
+	s := strings.TrimSpace(strings.Join(m, " "))
+	if s == "" {
+		return "NOP"
+	}
+	return s
+}
@@ -0,0 +1,34 @@
+package main
+
+import "time"
+
+// A device event, run between instructions once the simulated time reaches at
+type event struct {
+	at time.Duration
+	fn func()
+}
+
+// Runs fn after the machine has run for delay of simulated time. Devices use
+// this for anything that takes time on the real machine, like a character
+// being printed.
+func (mk *MK12) schedule(delay time.Duration, fn func()) {
+	e := event{at: mk.HW.TIME + delay, fn: fn}
+	// Keep the queue in time order, events due at the same time run in the
+	// order they were scheduled
+	i := len(mk.events)
+	for i > 0 && mk.events[i-1].at > e.at {
+		i--
+	}
+	mk.events = append(mk.events, event{})
+	copy(mk.events[i+1:], mk.events[i:])
+	mk.events[i] = e
+}
+
+// Runs the events that are due
+func (mk *MK12) runEvents() {
+	for len(mk.events) > 0 && mk.events[0].at <= mk.HW.TIME {
+		e := mk.events[0]
+		mk.events = mk.events[1:]
+		e.fn()
+	}
+}
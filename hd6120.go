@@ -0,0 +1,175 @@
+package main
+
+// HD-6120 IOTs in the memory extension device codes (IR bits 3-11)
+const (
+	PPC1 = 0o205 // Push PC+1 on stack 1
+	PAC1 = 0o215 // Push AC on stack 1
+	RTN1 = 0o225 // Return (pop PC) from stack 1
+	POP1 = 0o235 // Pop stack 1 into AC
+	PPC2 = 0o245 // Push PC+1 on stack 2
+	PAC2 = 0o255 // Push AC on stack 2
+	RTN2 = 0o265 // Return (pop PC) from stack 2
+	POP2 = 0o275 // Pop stack 2 into AC
+
+	PR0 = 0o206 // Panel request 0
+	PR1 = 0o216 // Panel request 1
+	PR2 = 0o226 // Panel request 2
+	PR3 = 0o236 // Panel request 3
+	WSR = 0o246 // Write switch register
+	GCF = 0o256 // Get current fields
+	CPD = 0o266 // Clear panel data flag
+	SPD = 0o276 // Set panel data flag
+
+	RSP1 = 0o207 // Read stack pointer 1
+	LSP1 = 0o217 // Load stack pointer 1
+	RSP2 = 0o227 // Read stack pointer 2
+	LSP2 = 0o237 // Load stack pointer 2
+)
+
+// HD-6120 processor IOTs that are only decoded in control panel mode
+const (
+	PRS = 0o0 // Read panel status
+	PGO = 0o3 // Clear the halt flag
+	PEX = 0o4 // Exit panel mode (at next JMP or JMS)
+)
+
+// Panel location the PC is saved in and the address execution starts at
+// when the HD-6120 enters control panel mode
+const (
+	PANEL_save  = 0o0000
+	PANEL_entry = 0o7777
+)
+
+// Executes the HD-6120 stack and panel IOTs that share device codes 20-27
+// with the memory extension. Returns false if the instruction isn't one.
+func (mk *MK12) hd6120IOT() bool {
+	switch mk.IR & 0o777 {
+	case PPC1:
+		mk.push(&mk.SP1, (mk.PC+1)&0o7777)
+	case PAC1:
+		mk.push(&mk.SP1, mk.AC)
+	case RTN1:
+		mk.PC = mk.pop(&mk.SP1)
+		mk.jump()
+	case POP1:
+		mk.AC = mk.pop(&mk.SP1)
+	case PPC2:
+		mk.push(&mk.SP2, (mk.PC+1)&0o7777)
+	case PAC2:
+		mk.push(&mk.SP2, mk.AC)
+	case RTN2:
+		mk.PC = mk.pop(&mk.SP2)
+		mk.jump()
+	case POP2:
+		mk.AC = mk.pop(&mk.SP2)
+
+	case PR0, PR1, PR2, PR3:
+		// Panel requests trap to the panel, they do nothing in panel mode
+		if !mk.PANEL.CTRL && mk.PMEM != nil {
+			mk.PANEL.PNLTRP = true
+			mk.panelTrap()
+		}
+	case WSR:
+		mk.SR = mk.AC
+		mk.AC = 0
+		mk.srWritten = true
+	case GCF:
+		mk.AC = mk.IF<<3 | mk.DF
+		if mk.L {
+			mk.AC |= 0o4000
+		}
+		if mk.GT {
+			mk.AC |= 0o2000
+		}
+		if mk.interruptRequest() {
+			mk.AC |= 0o1000
+		}
+		if mk.PANEL.PWRON {
+			mk.AC |= 0o0400
+		}
+		if mk.STATE.IE {
+			mk.AC |= 0o0200
+		}
+	case CPD:
+		mk.PANEL.PDF = false
+	case SPD:
+		mk.PANEL.PDF = true
+
+	case RSP1:
+		mk.AC = mk.SP1
+	case LSP1:
+		mk.SP1 = mk.AC
+		mk.AC = 0
+	case RSP2:
+		mk.AC = mk.SP2
+	case LSP2:
+		mk.SP2 = mk.AC
+		mk.AC = 0
+
+	default:
+		return false
+	}
+	return true
+}
+
+// Executes the processor IOTs that the HD-6120 decodes differently in panel
+// mode. Returns false if the instruction should be handled as usual.
+func (mk *MK12) panelIOT() bool {
+	switch mk.IR & 0o7 {
+	case PRS:
+		mk.AC = 0
+		if mk.PANEL.BTSTRP {
+			mk.AC |= 0o4000
+		}
+		if mk.PANEL.PNLTRP {
+			mk.AC |= 0o2000
+		}
+		if mk.PANEL.PWRON {
+			mk.AC |= 0o0400
+		}
+		if mk.PANEL.HLTFLG {
+			mk.AC |= 0o0200
+		}
+		mk.PANEL.BTSTRP = false
+		mk.PANEL.PNLTRP = false
+	case PGO:
+		mk.PANEL.HLTFLG = false
+	case PEX:
+		mk.PANEL.PEX = true
+		mk.PANEL.PWRON = false
+		mk.PANEL.PNLTRP = false
+	default:
+		return false
+	}
+	return true
+}
+
+// Enters control panel mode: the PC is saved in panel memory, the fields
+// are saved in SF and cleared, and execution continues at the panel entry
+// point with interrupts held off until the panel exits.
+func (mk *MK12) panelTrap() {
+	mk.writeMem(true, PANEL_save, mk.PC)
+	mk.SF = mk.IF<<3 | mk.DF
+	mk.IF = 0
+	mk.IB = 0
+	mk.DF = 0
+	mk.PC = PANEL_entry
+	mk.PANEL.CTRL = true
+	mk.PANEL.PDF = false
+	mk.PANEL.PEX = false
+}
+
+// Pushes data on the stack sp points to. Stacks grow down and live in field
+// 0 of the memory instructions are being fetched from.
+func (mk *MK12) push(sp *uint16, data uint16) {
+	mk.writeMem(mk.PANEL.CTRL, *sp, data)
+	*sp = (*sp - 1) & 0o7777
+	mk.cycles++
+}
+
+// Pops a word from the stack sp points to
+func (mk *MK12) pop(sp *uint16) uint16 {
+	*sp = (*sp + 1) & 0o7777
+	mk.cycles++
+	return mk.imem()[*sp]
+}
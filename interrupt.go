@@ -0,0 +1,149 @@
+package main
+
+import "fmt"
+
+// Processor IOT device code. These instructions are decoded by the CPU itself
+// rather than being sent out on the IO bus.
+const CPU_IOT = 0o00
+
+// Processor IOT function codes (IR bits 9-11)
+const (
+	SKON = 0o0 // Skip if interrupts on, turn them off
+	ION  = 0o1 // Interrupts on (after the next instruction)
+	IOF  = 0o2 // Interrupts off
+	SRQ  = 0o3 // Skip if an interrupt is being requested
+	GTF  = 0o4 // Get flags
+	RTF  = 0o5 // Restore flags
+	SGT  = 0o6 // Skip if greater than flag set
+	CAF  = 0o7 // Clear all flags
+)
+
+// Executes a processor IOT (device 00). Models without the full set only
+// decode ION and IOF, anything else is a no-op.
+func (mk *MK12) processorIOT() {
+	code := mk.IR & 0o7
+	if !mk.HW.MODEL.CAF {
+		if code == ION {
+			mk.interruptsOn()
+		} else if code == IOF {
+			mk.STATE.IE = false
+		}
+		return
+	}
+
+	switch code {
+	case SKON:
+		if mk.STATE.IE {
+			mk.PC = (mk.PC + 1) % 4096
+		}
+		mk.STATE.IE = false
+
+	case ION:
+		mk.interruptsOn()
+
+	case IOF:
+		mk.STATE.IE = false
+
+	case SRQ:
+		if mk.interruptRequest() {
+			mk.PC = (mk.PC + 1) % 4096
+		}
+
+	case GTF:
+		mk.AC = 0
+		if mk.L {
+			mk.AC |= 0o4000
+		}
+		if mk.GT {
+			mk.AC |= 0o2000
+		}
+		if mk.interruptRequest() {
+			mk.AC |= 0o1000
+		}
+		if mk.II {
+			mk.AC |= 0o0400
+		}
+		if mk.STATE.IE {
+			mk.AC |= 0o0200
+		}
+		mk.AC |= mk.SF
+
+	case RTF:
+		mk.L = mk.AC&0o4000 != 0
+		mk.GT = mk.AC&0o2000 != 0
+		// The saved fields are restored, IF at the next JMP
+		mk.IB = (mk.AC >> 3) & 0o7 & mk.fieldMask
+		mk.DF = mk.AC & 0o7 & mk.fieldMask
+		mk.II = true
+		mk.interruptsOn()
+
+	case SGT:
+		if mk.GT {
+			mk.PC = (mk.PC + 1) % 4096
+		}
+
+	case CAF:
+		mk.clearAllFlags()
+	}
+}
+
+// Turns the interrupt system on. As on the real machine this takes effect
+// after the next instruction, so an ION followed by a JMP I 0 can return from
+// an interrupt routine before the next interrupt is taken.
+func (mk *MK12) interruptsOn() {
+	mk.STATE.IE = true
+	mk.ieDelay = true
+}
+
+// Clears AC, the link, the interrupt system and every device flag.
+func (mk *MK12) clearAllFlags() {
+	mk.AC = 0
+	mk.L = false
+	mk.GT = false
+	mk.STATE.IE = false
+	mk.ieDelay = false
+	for _, dev := range mk.IOT {
+		if c, ok := dev.(Clearer); ok {
+			c.ClearFlags()
+		}
+	}
+}
+
+// Returns true if any device is requesting an interrupt
+func (mk *MK12) interruptRequest() bool {
+	for _, dev := range mk.IOT {
+		if i, ok := dev.(Interrupter); ok && i.InterruptRequest() {
+			return true
+		}
+	}
+	return false
+}
+
+// Called between instructions, takes an interrupt if one is pending. An
+// interrupt is a JMS to location 0 of field 0 with the interrupt system turned
+// off, the fields are saved in SF. Interrupts are held off while a CIF is
+// waiting for its JMP and while an HD-6120 is in panel mode.
+func (mk *MK12) interrupt() {
+	if !mk.STATE.IE || mk.II || mk.PANEL.CTRL {
+		return
+	}
+	if mk.ieDelay {
+		mk.ieDelay = false
+		return
+	}
+	if !mk.interruptRequest() {
+		return
+	}
+
+	mk.STATE.IE = false
+	if mk.trace != nil {
+		fmt.Fprintf(mk.trace, "----  ----  INTERRUPT ; RET %o\n", mk.PC)
+	}
+	mk.SF = mk.IF<<3 | mk.DF
+	mk.IF = 0
+	mk.IB = 0
+	mk.DF = 0
+	mk.write(INT_vect, mk.PC)
+	mk.PC = INT_vect + 1
+	mk.HW.TIME += mk.HW.MODEL.Cycle
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// LE8 line printer interface device code, also used by the LA180
+const LP_DEV = 0o66
+
+// LE8 function codes
+const (
+	PSKF = 0o1 // Skip on printer flag
+	PCLF = 0o2 // Clear the printer flag
+	PSKE = 0o3 // Skip on printer error
+	PSTB = 0o4 // Print the character in AC
+	PSIE = 0o5 // Set the printer interrupt enable
+	PCIE = 0o7 // Clear the printer interrupt enable
+)
+
+// The LA180 prints 180 characters a second
+const LP_charTime = time.Second / 180
+
+// LinePrinter is an LE8 or LA180 printing to a text file or stdout. The flag
+// is set once the character given by PSTB has printed, and the error flag if
+// the output can't be written. CR returns the carriage so the rest of the line
+// is printed over it, LF and FF print the line, FF then going to the top of
+// the next page, and characters past the last column are lost. In green bar
+// mode every line is printed at full width between the tractor holes with
+// every other three lines marked as a green band, and pages are separated by
+// the perforation.
+type LinePrinter struct {
+	Device
+
+	Columns  int
+	Lines    int // Lines on a page
+	GreenBar bool
+
+	out  *bufio.Writer
+	file *os.File // nil for stdout
+
+	line   []byte // Printed so far on this line
+	col    int
+	lineNo int // Lines printed on this page
+
+	Flag  bool
+	Error bool
+	IE    bool // Set on power up and by CAF
+
+	busy bool
+	mk   *MK12
+	ac   uint16
+}
+
+// Creates a printer printing to the file at path, or stdout for "-"
+func NewLinePrinter(path string, columns int, lines int, greenBar bool) (*LinePrinter, error) {
+	if columns < 1 || lines < 1 {
+		return nil, fmt.Errorf("a line printer needs at least one column and one line a page")
+	}
+	lp := &LinePrinter{Columns: columns, Lines: lines, GreenBar: greenBar, IE: true}
+	if path == "-" {
+		lp.out = bufio.NewWriter(os.Stdout)
+	} else {
+		f, err := os.Create(path)
+		if err != nil {
+			return nil, err
+		}
+		lp.file = f
+		lp.out = bufio.NewWriter(f)
+	}
+	return lp, nil
+}
+
+func (lp *LinePrinter) Select(addr uint16, mk *MK12) bool {
+	if addr != LP_DEV {
+		return false
+	}
+	lp.mk = mk
+	lp.ac = mk.AC
+	return true
+}
+
+func (lp *LinePrinter) Get() uint16 {
+	return 0
+}
+
+func (lp *LinePrinter) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch fn {
+	case PSKF:
+		skip = lp.Flag
+	case PCLF:
+		lp.Flag = false
+	case PSKE:
+		skip = lp.Error
+	case PSTB:
+		lp.start(byte(lp.ac))
+	case PSIE:
+		lp.IE = true
+	case PCLF | PSTB:
+		lp.Flag = false
+		lp.start(byte(lp.ac))
+	case PCIE:
+		lp.IE = false
+	}
+	return
+}
+
+// Prints a character, setting the flag once it is done
+func (lp *LinePrinter) start(c byte) {
+	if lp.busy {
+		return
+	}
+	lp.busy = true
+	lp.mk.schedule(LP_charTime, func() {
+		lp.busy = false
+		lp.print(c)
+		lp.Flag = true
+	})
+}
+
+func (lp *LinePrinter) print(c byte) {
+	switch c &= 0o177; {
+	case c == '\r':
+		lp.col = 0
+	case c == '\n':
+		lp.newLine()
+	case c == '\f':
+		if len(lp.line) > 0 {
+			lp.newLine()
+		}
+		for lp.lineNo != 0 {
+			lp.newLine()
+		}
+	case c == '\t':
+		lp.col = (lp.col + 8) &^ 7
+	case c >= ' ' && c < 0o177:
+		if lp.col < lp.Columns {
+			for len(lp.line) <= lp.col {
+				lp.line = append(lp.line, ' ')
+			}
+			// Overprinting shows the last character, except that
+			// underlining leaves the character underlined
+			if c != ' ' && (c != '_' || lp.line[lp.col] == ' ') {
+				lp.line[lp.col] = c
+			}
+		}
+		lp.col++
+	}
+}
+
+// Prints the line and moves the paper up one, onto the next page after the
+// last line of a page
+func (lp *LinePrinter) newLine() {
+	text := strings.TrimRight(string(lp.line), " ")
+	if lp.GreenBar {
+		hole, band := ' ', ' '
+		if lp.lineNo%3 == 1 {
+			hole = 'o'
+		}
+		if lp.lineNo/3%2 == 1 {
+			band = ':'
+		}
+		fmt.Fprintf(lp.out, "%c %c%-*s%c %c\n", hole, band, lp.Columns, text, band, hole)
+	} else {
+		lp.out.WriteString(text + "\n")
+	}
+	lp.line = lp.line[:0]
+	lp.col = 0
+	lp.lineNo++
+	if lp.lineNo == lp.Lines {
+		lp.lineNo = 0
+		if lp.GreenBar {
+			lp.out.WriteString(strings.Repeat("- ", (lp.Columns+6)/2) + "\n")
+		}
+	}
+	if err := lp.out.Flush(); err != nil {
+		lp.Error = true
+	}
+}
+
+func (lp *LinePrinter) InterruptRequest() bool {
+	return lp.IE && (lp.Flag || lp.Error)
+}
+
+// CAF clears the flag and sets the interrupt enable
+func (lp *LinePrinter) ClearFlags() {
+	lp.Flag = false
+	lp.IE = true
+}
+
+// Prints what is left of the last line and closes the output
+func (lp *LinePrinter) Close() {
+	if len(lp.line) > 0 {
+		lp.newLine()
+	}
+	lp.out.Flush()
+	if lp.file != nil {
+		lp.file.Close()
+	}
+}
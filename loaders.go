@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A Loader is a bootstrap built into the simulator, saving it being toggled
+// in from the front panel
+type Loader struct {
+	// One line description shown when loaders are listed
+	Help string
+
+	// Address of the first word, in field 0
+	Origin uint16
+
+	// Where the loader is started
+	Start uint16
+
+	Words []uint16
+
+	// Patches a copy of Words to boot another drive, nil if the loader only
+	// boots drive 0
+	SetUnit func(words []uint16, unit int)
+}
+
+// Built-in loaders by name
+var loaders = map[string]Loader{}
+
+// Deposits a loader and loads its start address, as LOAD ADD would, so
+// CONT runs it
+func (mk *MK12) deposit(l Loader) {
+	for i, w := range l.Words {
+		mk.write(l.Origin+uint16(i), w)
+	}
+	mk.IF, mk.IB, mk.DF = 0, 0, 0
+	mk.PC = l.Start
+	mk.MA = l.Start
+}
+
+// Deposits the loader called name, returning it
+func (mk *MK12) loader(name string) (Loader, error) {
+	l, ok := loaders[strings.ToLower(name)]
+	if !ok {
+		return l, fmt.Errorf("no loader called %q, try loader", name)
+	}
+	mk.deposit(l)
+	return l, nil
+}
+
+// Boots from a drive given as drive or drive=path[,ro], attaching the image
+// if there is a path. Flags are cleared as by CLEAR and the bootstrap of the
+// drive's controller is deposited and started at its entry.
+func (mk *MK12) boot(spec string) error {
+	drive, _, attach := strings.Cut(spec, "=")
+	if attach {
+		if err := mk.attachImages([]string{spec}); err != nil {
+			return err
+		}
+	}
+	name, unit, err := parseDrive(drive)
+	if err != nil {
+		return err
+	}
+	l, ok := loaders[name]
+	if !ok {
+		return fmt.Errorf("can't boot from %s, there is no bootstrap for %s drives", drive, name)
+	}
+	s, err := mk.storage(name)
+	if err != nil {
+		return err
+	}
+	if unit >= s.Drives() || s.Image(unit) == nil {
+		return fmt.Errorf("nothing is attached to %s", drive)
+	}
+	l.Words = append([]uint16(nil), l.Words...)
+	if l.SetUnit != nil {
+		l.SetUnit(l.Words, unit)
+	} else if unit != 0 {
+		return fmt.Errorf("the %s bootstrap only boots %s0", name, name)
+	}
+	mk.clearAllFlags()
+	mk.deposit(l)
+	return nil
+}
+
+func init() {
+	debugCommands["loader"] = DebugCommand{
+		Usage: "[name]",
+		Help:  "Deposit a built-in loader and load its start address, or list them",
+		Run:   cmdLoader,
+	}
+}
+
+func cmdLoader(mk *MK12, args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		var lines []string
+		for name, l := range loaders {
+			lines = append(lines, fmt.Sprintf("%-4s %04o %s", name, l.Start, l.Help))
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n"), nil
+	case 1:
+		l, err := mk.loader(args[0])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s loader at %04o", args[0], l.Start), nil
+	}
+	return "", fmt.Errorf("usage: loader [name]")
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A program that loads 1234 into AC and halts, to be loaded at addr
+func bootProgram(addr uint16) []uint16 {
+	tad := 0o1000 | (addr+3)&0o177
+	if addr >= 0o200 {
+		tad |= 0o200
+	}
+	return []uint16{0o7200, tad, 0o7402, 0o1234}
+}
+
+// A boot block of n words with the program for addr at word i and HLTs
+// elsewhere, so the program only runs if the block lands where it should
+func bootBlock(n int, i int, addr uint16) []uint16 {
+	block := make([]uint16, n)
+	for j := range block {
+		block[j] = 0o7402
+	}
+	copy(block[i:], bootProgram(addr))
+	return block
+}
+
+// Writes words into a new image at word offset off
+func writeBootImage(t *testing.T, name string, off int64, words []uint16) string {
+	path := filepath.Join(t.TempDir(), name)
+	img, err := openImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer img.Close()
+	if err := img.WriteWords(off, words); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Boots from drive with path attached and checks the boot block's program
+// runs
+func testBoot(t *testing.T, drive string, path string) {
+	mk := newTestMK12(t)
+	if err := mk.boot(drive + "=" + path); err != nil {
+		t.Fatal(err)
+	}
+	defer mk.detachAll()
+	if !runTest(mk, 10000000) {
+		t.Fatalf("still running at %05o", mk.PC)
+	}
+	if mk.AC != 0o1234 {
+		t.Fatalf("halted at %05o with AC %04o, the boot block didn't run", mk.PC, mk.AC)
+	}
+}
+
+// Block 0 is read into 0000 over the JMP . at 0031 the bootstrap waits in
+func TestBootRK(t *testing.T) {
+	testBoot(t, "rk0", writeBootImage(t, "boot.rk05", 0, bootBlock(0o400, 0o31, 0o31)))
+}
+
+// Block 0 is read into 7600 and started there
+func TestBootDT(t *testing.T) {
+	testBoot(t, "dt0", writeBootImage(t, "boot.tu56", 0, bootBlock(DT_WORDS, 0, 0o7600)))
+}
+
+// 128 words from the start of the disk are read into 6604 and started at 6622
+func TestBootDF(t *testing.T) {
+	testBoot(t, "df0", writeBootImage(t, "boot.df32", 0, bootBlock(0o200, 0o6622-0o6604, 0o6622)))
+}
+
+// Track 1 sector 1 is emptied over the bootstrap from location 2 on, so it
+// has to carry on the bootstrap's loop. Once the silo is empty the loop goes
+// to 0033, which jumps to the program at 0002.
+func TestBootRX(t *testing.T) {
+	sector := bootBlock(RX_WORDS, 0, 2)
+	copy(sector[0o46-2:], rxBoot[0o46-0o22:0o60-0o22])
+	sector[0o50-2] = 0o3050 // DCA 2 has been incremented to here
+	sector[0o33-2] = 0o5002
+
+	// Two 12-bit words are packed into three bytes
+	b := make([]byte, RX_BYTES)
+	for i := 0; i < len(sector); i += 2 {
+		w, v := sector[i], sector[i+1]
+		b[3*i/2] = byte(w >> 4)
+		b[3*i/2+1] = byte(w&0o17)<<4 | byte(v>>8)
+		b[3*i/2+2] = byte(v)
+	}
+	path := filepath.Join(t.TempDir(), "boot.rx01")
+	img, err := openImage(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = img.WriteBytes((1*RX_SECTORS+1-1)*RX_BYTES, b)
+	img.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testBoot(t, "rx0", path)
+}
+
+// Boots a copy of the OS/8 RK05 image in $MKSIM_OS8_RK05 and waits for the
+// keyboard monitor's prompt. No image is checked in, so without one this is
+// skipped.
+func TestBootOS8(t *testing.T) {
+	src := os.Getenv("MKSIM_OS8_RK05")
+	if src == "" {
+		t.Skip("no OS/8 image checked in, set MKSIM_OS8_RK05 to an RK05 image to boot it")
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	path := filepath.Join(t.TempDir(), "os8.rk05")
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = io.Copy(out, in)
+	out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mk := newTestMK12(t)
+	var printed bytes.Buffer
+	printer := bufio.NewWriter(&printed)
+	mk.IOT = append(mk.IOT, NewTeleTypeDevice(TT_KEYBOARD, bufio.NewReader(strings.NewReader("")), printer))
+	if err := mk.boot("rk0=" + path); err != nil {
+		t.Fatal(err)
+	}
+	defer mk.detachAll()
+	for i := 0; i < 100; i++ {
+		if runTest(mk, 1000000) {
+			t.Fatalf("halted at %05o, printed %q", mk.PC, printed.String())
+		}
+		printer.Flush()
+		if strings.HasSuffix(strings.TrimRight(printed.String(), "\r\n"), ".") {
+			return
+		}
+	}
+	t.Fatalf("no prompt, printed %q", printed.String())
+}
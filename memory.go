@@ -0,0 +1,111 @@
+package main
+
+import "fmt"
+
+// Memory extension (KM8-E) device codes 20-27. The field to change to is
+// encoded in the device code: 62N1 CDF, 62N2 CIF.
+const (
+	MEM_IOT_begin = 0o20
+	MEM_IOT_end   = 0o27
+)
+
+// Memory extension function codes (IR bits 9-11)
+const (
+	CDF = 0o1 // Change data field
+	CIF = 0o2 // Change instruction field (at next JMP or JMS)
+	// Device 21-24 with function code 4 read the field registers
+	RDF = 0o214 // Read data field
+	RIF = 0o224 // Read instruction field
+	RIB = 0o234 // Read interrupt buffer (saved fields)
+	RMF = 0o244 // Restore memory fields
+)
+
+// Valid memory sizes in K words, one field per 4K
+var MemorySizes = []int{4, 8, 16, 32}
+
+// Allocates memory for the machine. Size is in K words and must be one of
+// MemorySizes.
+func (mk *MK12) installMemory(size int) error {
+	for _, s := range MemorySizes {
+		if s == size {
+			mk.MEM = make([]uint16, size*1024)
+			mk.fieldMask = uint16(size/4 - 1)
+			mk.invalidate()
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported memory size %dK (valid sizes: %v)", size, MemorySizes)
+}
+
+// Returns true if the memory extension IOTs are decoded, either because the
+// model has the field registers on chip or more than 4K is installed.
+func (mk *MK12) hasMemoryExtension() bool {
+	return mk.HW.MODEL.Fields || len(mk.MEM) > 4096
+}
+
+// Returns the memory instructions are fetched from, this is panel memory
+// while an HD-6120 is in control panel mode.
+func (mk *MK12) imem() []uint16 {
+	if mk.PANEL.CTRL {
+		return mk.PMEM
+	}
+	return mk.MEM
+}
+
+// Reads the word MA refers to, in field mf of main or panel memory
+func (mk *MK12) load() uint16 {
+	if mk.mpanel {
+		return mk.PMEM[mk.mf<<12|mk.MA]
+	}
+	return mk.MEM[mk.mf<<12|mk.MA]
+}
+
+// Stores data in the word MA refers to
+func (mk *MK12) store(data uint16) {
+	mk.writeMem(mk.mpanel, mk.mf<<12|mk.MA, data)
+}
+
+// Called when a JMP or JMS executes: the instruction field buffer is
+// transferred to IF, re-enabling interrupts held off by CIF, and a pending
+// panel exit takes place.
+func (mk *MK12) jump() {
+	mk.IF = mk.IB
+	mk.II = false
+	if mk.PANEL.PEX {
+		mk.PANEL.PEX = false
+		mk.PANEL.CTRL = false
+	}
+}
+
+// Executes a memory extension IOT (device 20-27)
+func (mk *MK12) memoryIOT() {
+	field := (mk.IR >> 3) & 0o7 & mk.fieldMask
+
+	switch mk.IR & 0o7 {
+	case CDF:
+		mk.DF = field
+		return
+	case CIF:
+		mk.IB = field
+		mk.II = true
+		return
+	case CDF | CIF:
+		mk.DF = field
+		mk.IB = field
+		mk.II = true
+		return
+	}
+
+	switch mk.IR & 0o777 {
+	case RDF:
+		mk.AC |= mk.DF << 3
+	case RIF:
+		mk.AC |= mk.IF << 3
+	case RIB:
+		mk.AC |= mk.SF
+	case RMF:
+		mk.IB = (mk.SF >> 3) & mk.fieldMask
+		mk.DF = mk.SF & 0o7 & mk.fieldMask
+		mk.II = true
+	}
+}
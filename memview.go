@@ -0,0 +1,484 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jroimartin/gocui"
+)
+
+// Text shown next to the words in the memory viewer
+const (
+	TEXT_NONE   = iota
+	TEXT_ASCII  // Low 7 bits of each word as ASCII
+	TEXT_SIXBIT // Each word as two sixbit characters
+)
+
+// Number of front panel updates a changed word stays highlighted for
+const MEM_recent = 8
+
+// State of the memory viewer. It is only used on the gocui goroutine, the CPU
+// copies the page it asks for through memoryWanted and hands the copy over
+// through g.Update.
+type memoryViewer struct {
+	// Machine state from the last front panel update. words and breakpoints
+	// hold the page at wordsPage, which lags page until the CPU has copied it.
+	size        int // Words of memory installed
+	words       []uint16
+	breakpoints []bool
+	wordsPage   uint16
+	pc          uint16 // 15-bit address of the next instruction
+	ma          uint16 // 15-bit address in MA
+	halted      bool
+
+	// 15-bit address of the first word of the page shown
+	page uint16
+
+	// If locked is set the page was chosen with -lock or navigation, otherwise
+	// the viewer follows the PC
+	locked bool
+
+	// If editing is set the memory view has the keyboard and a cursor
+	editing bool
+	cursor  uint16
+	entry   string // Octal digits typed at the cursor
+
+	// If prompting is set the goto/command prompt is open
+	prompting bool
+
+	// Text column, TEXT_NONE, TEXT_ASCII or TEXT_SIXBIT
+	text int
+
+	// Words of the page at shadowPage as last shown, and how many updates are
+	// left before a changed word stops being highlighted
+	shadow     [0o200]uint16
+	age        [0o200]uint8
+	shadowPage uint16
+	shadowed   bool
+}
+
+var memView memoryViewer
+
+// The page the memory viewer wants copied, set on the gocui goroutine and
+// read by the CPU. If follow is set the CPU copies the page of the PC.
+var memoryWanted struct {
+	sync.Mutex
+	page   uint16
+	follow bool
+}
+
+// Commands typed at the prompt, run by the CPU between instructions
+var panelCommands = make(chan string, 16)
+
+func bindMemoryViewer(g *gocui.Gui) error {
+	bindings := []struct {
+		view    string
+		key     interface{}
+		handler func(*gocui.Gui, *gocui.View) error
+	}{
+		{"", gocui.KeyPgup, memoryPageUp},
+		{"", gocui.KeyPgdn, memoryPageDown},
+		{"", gocui.KeyCtrlE, memoryEdit},
+		{"", gocui.KeyCtrlG, openPrompt},
+		{"", gocui.KeyCtrlA, memoryText},
+		{"memory", gocui.KeyArrowLeft, memoryCursor(-1)},
+		{"memory", gocui.KeyArrowRight, memoryCursor(1)},
+		{"memory", gocui.KeyArrowUp, memoryCursor(-8)},
+		{"memory", gocui.KeyArrowDown, memoryCursor(8)},
+		{"memory", '[', memoryCursor(-0o10000)},
+		{"memory", ']', memoryCursor(0o10000)},
+		{"memory", gocui.KeyEnter, memoryCommit},
+		{"memory", gocui.KeyEsc, memoryEscape},
+		{"memory", gocui.KeyBackspace, memoryBackspace},
+		{"memory", gocui.KeyBackspace2, memoryBackspace},
+		{"memory", 'b', memoryBreakpoint},
+		{"prompt", gocui.KeyEnter, submitPrompt},
+		{"prompt", gocui.KeyEsc, closePrompt},
+		{"prompt", gocui.KeySpace, promptSpace},
+	}
+	for digit := '0'; digit <= '7'; digit++ {
+		bindings = append(bindings, struct {
+			view    string
+			key     interface{}
+			handler func(*gocui.Gui, *gocui.View) error
+		}{"memory", digit, memoryDigit(digit)})
+	}
+	for _, b := range bindings {
+		if err := g.SetKeybinding(b.view, b.key, gocui.ModNone, b.handler); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Copies the page the memory viewer wants, hands it over and redraws
+func updateMemory(g *gocui.Gui, mk *MK12) {
+	size := len(mk.MEM)
+	if size == 0 {
+		return
+	}
+	pc := mk.IF<<12 | mk.PC
+	ma := mk.mf<<12 | mk.MA
+	halted := mk.STATE.HALT
+
+	memoryWanted.Lock()
+	page, follow := memoryWanted.page, memoryWanted.follow
+	memoryWanted.Unlock()
+	if follow {
+		page = pc
+	}
+	page = uint16(int(page)%size) &^ 0o177
+	words := make([]uint16, 0o200)
+	copy(words, mk.MEM[page:])
+	var breakpoints []bool
+	if mk.breakpoints != nil {
+		breakpoints = make([]bool, 0o200)
+		copy(breakpoints, mk.breakpoints[page:])
+	}
+
+	g.Update(func(g *gocui.Gui) error {
+		mv := &memView
+		mv.size = size
+		mv.pc = pc
+		mv.ma = ma
+		mv.halted = halted
+		if follow && !mv.locked && !mv.editing {
+			mv.page = page
+		}
+		if page != mv.page {
+			// Moved on since the CPU copied it
+			return mv.draw(g)
+		}
+		mv.words, mv.breakpoints, mv.wordsPage = words, breakpoints, page
+		mv.track()
+		return mv.draw(g)
+	})
+}
+
+// Shows the page containing addr, wrapping around the installed memory
+func (mv *memoryViewer) setPage(addr int) {
+	if mv.size == 0 {
+		return
+	}
+	mv.page = uint16(((addr%mv.size)+mv.size)%mv.size) &^ 0o177
+	mv.want()
+}
+
+// Tells the CPU which page to copy. A page it hasn't copied yet is asked
+// for straight away, as nothing else updates the front panel while halted.
+func (mv *memoryViewer) want() {
+	memoryWanted.Lock()
+	memoryWanted.page = mv.page
+	memoryWanted.follow = !mv.locked && !mv.editing
+	memoryWanted.Unlock()
+	if mv.words != nil && mv.wordsPage != mv.page {
+		select {
+		case panelCommands <- refreshCommand:
+		default:
+		}
+	}
+}
+
+// Ages the highlights on the page copied and marks the words that changed.
+// A page that wasn't shown last time is taken as it is, with nothing on it
+// highlighted as recently written.
+func (mv *memoryViewer) track() {
+	if !mv.shadowed || mv.shadowPage != mv.wordsPage {
+		copy(mv.shadow[:], mv.words)
+		mv.age = [0o200]uint8{}
+		mv.shadowPage, mv.shadowed = mv.wordsPage, true
+		return
+	}
+	for i, word := range mv.words {
+		if mv.age[i] > 0 {
+			mv.age[i]--
+		}
+		if word != mv.shadow[i] {
+			mv.shadow[i] = word
+			mv.age[i] = MEM_recent
+		}
+	}
+}
+
+// Width taken by the text column
+func (mv *memoryViewer) textWidth() int {
+	switch mv.text {
+	case TEXT_ASCII:
+		return 9
+	case TEXT_SIXBIT:
+		return 17
+	}
+	return 0
+}
+
+func (mv *memoryViewer) draw(g *gocui.Gui) error {
+	v, err := g.View("memory")
+	if err != nil {
+		return err
+	}
+	v.Clear()
+	if mv.size == 0 {
+		return nil
+	}
+
+	switch {
+	case mv.editing:
+		v.Title = fmt.Sprintf(" PAGE %05o EDIT ", mv.page)
+	case mv.locked:
+		v.Title = fmt.Sprintf(" PAGE %05o LOCKED ", mv.page)
+	default:
+		v.Title = fmt.Sprintf(" PAGE %05o ", mv.page)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%05o  0    1    2    3    4    5    6    7", mv.page)
+	if mv.wordsPage != mv.page || mv.words == nil {
+		fmt.Fprint(v, sb.String())
+		return nil
+	}
+	for row := uint16(0); row < 0o200; row += 0o10 {
+		fmt.Fprintf(&sb, "\n%02o  ", row&0o77)
+		for col := uint16(0); col < 0o10; col++ {
+			sb.WriteString(mv.cell(mv.page + row + col))
+			sb.WriteByte(' ')
+		}
+		for col := uint16(0); col < 0o10 && mv.text != TEXT_NONE; col++ {
+			sb.WriteString(textChars(mv.words[row+col], mv.text))
+		}
+	}
+	fmt.Fprint(v, sb.String())
+	return nil
+}
+
+// Formats one word, highlighting the PC (green), MA (yellow), recently
+// written words (cyan), breakpoints (red) and the cursor (reversed)
+func (mv *memoryViewer) cell(addr uint16) string {
+	i := addr - mv.page
+	word := fmt.Sprintf("%04o", mv.words[i])
+	cursor := mv.editing && addr == mv.cursor
+	if cursor && mv.entry != "" {
+		word = mv.entry + strings.Repeat("_", 4-len(mv.entry))
+	}
+
+	var attrs []string
+	switch {
+	case addr == mv.pc:
+		attrs = append(attrs, "32", "1")
+	case addr == mv.ma:
+		attrs = append(attrs, "33")
+	case mv.age[i] > 0:
+		attrs = append(attrs, "36")
+	}
+	if mv.breakpoints != nil && mv.breakpoints[i] {
+		attrs = append(attrs, "41")
+	}
+	if cursor {
+		attrs = append(attrs, "7")
+	}
+	if len(attrs) == 0 {
+		return word
+	}
+	return "\x1b[" + strings.Join(attrs, ";") + "m" + word + "\x1b[0m"
+}
+
+// Formats a word for the text column
+func textChars(word uint16, mode int) string {
+	if mode == TEXT_SIXBIT {
+		return string([]byte{sixbitChar(word >> 6), sixbitChar(word)})
+	}
+	c := byte(word & 0o177)
+	if c < 0o40 || c == 0o177 {
+		return "."
+	}
+	return string(c)
+}
+
+// Sixbit codes 00-37 are @A-Z[\]^_ and 40-77 are space to ?
+func sixbitChar(c uint16) byte {
+	c &= 0o77
+	if c < 0o40 {
+		return byte(c + 0o100)
+	}
+	return byte(c)
+}
+
+// Moves the cursor to addr, taking the page along with it
+func (mv *memoryViewer) setCursor(addr int) {
+	if mv.size == 0 {
+		return
+	}
+	mv.cursor = uint16(((addr % mv.size) + mv.size) % mv.size)
+	mv.entry = ""
+	mv.locked = true
+	mv.setPage(int(mv.cursor))
+}
+
+// Queues a command for the CPU to run
+func sendCommand(g *gocui.Gui, line string) {
+	select {
+	case panelCommands <- line:
+	default:
+		debugPrint(g, "ERROR: too many commands waiting")
+	}
+}
+
+func memoryPageUp(g *gocui.Gui, v *gocui.View) error {
+	return memoryMove(g, -0o200)
+}
+
+func memoryPageDown(g *gocui.Gui, v *gocui.View) error {
+	return memoryMove(g, 0o200)
+}
+
+// Moves the cursor, or the page when not editing
+func memoryMove(g *gocui.Gui, offset int) error {
+	mv := &memView
+	if mv.editing {
+		mv.setCursor(int(mv.cursor) + offset)
+	} else {
+		mv.locked = true
+		mv.setPage(int(mv.page) + offset)
+	}
+	return mv.draw(g)
+}
+
+func memoryCursor(offset int) func(*gocui.Gui, *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		return memoryMove(g, offset)
+	}
+}
+
+// Ctrl+E moves the keyboard to the memory viewer and back
+func memoryEdit(g *gocui.Gui, v *gocui.View) error {
+	mv := &memView
+	if mv.prompting || mv.size == 0 {
+		return nil
+	}
+	if mv.editing {
+		return leaveMemory(g)
+	}
+	mv.editing = true
+	if mv.pc&^0o177 == mv.page {
+		mv.setCursor(int(mv.pc))
+	} else {
+		mv.setCursor(int(mv.page))
+	}
+	if _, err := g.SetCurrentView("memory"); err != nil {
+		return err
+	}
+	return mv.draw(g)
+}
+
+func leaveMemory(g *gocui.Gui) error {
+	memView.editing = false
+	memView.entry = ""
+	if _, err := g.SetCurrentView("teletype"); err != nil {
+		return err
+	}
+	return memView.draw(g)
+}
+
+func memoryDigit(digit rune) func(*gocui.Gui, *gocui.View) error {
+	return func(g *gocui.Gui, v *gocui.View) error {
+		if len(memView.entry) < 4 {
+			memView.entry += string(digit)
+		}
+		return memView.draw(g)
+	}
+}
+
+func memoryBackspace(g *gocui.Gui, v *gocui.View) error {
+	if n := len(memView.entry); n > 0 {
+		memView.entry = memView.entry[:n-1]
+	}
+	return memView.draw(g)
+}
+
+// Enter deposits the digits typed at the cursor and moves on to the next word
+func memoryCommit(g *gocui.Gui, v *gocui.View) error {
+	mv := &memView
+	if mv.entry != "" {
+		if !mv.halted {
+			debugPrint(g, "Halt the machine to change memory")
+			return nil
+		}
+		sendCommand(g, fmt.Sprintf("deposit %05o %s", mv.cursor, mv.entry))
+	}
+	mv.setCursor(int(mv.cursor) + 1)
+	return mv.draw(g)
+}
+
+// Esc throws away the digits typed, or leaves the memory viewer
+func memoryEscape(g *gocui.Gui, v *gocui.View) error {
+	if memView.entry != "" {
+		memView.entry = ""
+		return memView.draw(g)
+	}
+	return leaveMemory(g)
+}
+
+func memoryBreakpoint(g *gocui.Gui, v *gocui.View) error {
+	sendCommand(g, fmt.Sprintf("break %05o", memView.cursor))
+	return nil
+}
+
+// Ctrl+A steps the text column through none, ASCII and sixbit
+func memoryText(g *gocui.Gui, v *gocui.View) error {
+	memView.text = (memView.text + 1) % 3
+	return memView.draw(g)
+}
+
+// Ctrl+G opens the prompt, the view is created by layout
+func openPrompt(g *gocui.Gui, v *gocui.View) error {
+	memView.prompting = true
+	return nil
+}
+
+func closePrompt(g *gocui.Gui, v *gocui.View) error {
+	memView.prompting = false
+	g.Cursor = false
+	if err := g.DeleteView("prompt"); err != nil {
+		return err
+	}
+	current := "teletype"
+	if memView.editing {
+		current = "memory"
+	}
+	_, err := g.SetCurrentView(current)
+	return err
+}
+
+// Space is a front panel key, so the prompt has to insert it itself
+func promptSpace(g *gocui.Gui, v *gocui.View) error {
+	v.EditWrite(' ')
+	return nil
+}
+
+// An octal address moves the memory viewer there, an empty line goes back to
+// following the PC and anything else is run as a debug console command
+func submitPrompt(g *gocui.Gui, v *gocui.View) error {
+	line := strings.TrimSpace(v.Buffer())
+	if err := closePrompt(g, v); err != nil {
+		return err
+	}
+	mv := &memView
+	if line == "" {
+		mv.locked = false
+		mv.setPage(int(mv.pc))
+		return mv.draw(g)
+	}
+	if addr, err := strconv.ParseUint(line, 8, 15); err == nil {
+		if mv.editing {
+			mv.setCursor(int(addr))
+		} else {
+			mv.locked = true
+			mv.setPage(int(addr))
+		}
+		return mv.draw(g)
+	}
+	debugPrint(g, "> "+line)
+	sendCommand(g, line)
+	return nil
+}
@@ -3,8 +3,8 @@ package main
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
-	"path"
 	"strconv"
 	"time"
 )
@@ -39,10 +39,14 @@ const (
 
 // The Front Panel relays information back to the user about the runtime status
 type FrontPanel interface {
-	PowerOn(mk MK12)           // Called on start with a mostly-default mk-12
-	PowerOff()                 // Called on shutdown
-	Update(mk MK12)            // Update the register bulbs/display
-	ReadSwitches() (sr uint16) // Read the front panel switches
+	PowerOn(mk MK12)                             // Called on start with a mostly-default mk-12
+	PowerOff()                                   // Called on shutdown
+	Update(mk MK12)                              // Update the register bulbs/display
+	ReadSwitches() (sr uint16)                   // Read the front panel switches
+	ReadFieldSwitches() (ifs uint16, dfs uint16) // Read the instruction and data field switches
+	ReadKey() (key PanelKey)                     // Read the last key pressed, KEY_NONE if none
+	ReadCommand() (line string)                  // Read a debug console command, "" if none
+	Print(msg string)                            // Show the output of a debug console command
 }
 
 // This structure contains the various components of a theoretical MK-12
@@ -55,28 +59,106 @@ type MK12 struct {
 	IR uint16
 
 	// Decoded instruction register
+	// Only filled in when decodeIR is set, formatting it for every instruction
+	// is the single most expensive part of execute.
 	IRd string
 
+	// If decodeIR is set, execute describes each instruction in IRd. Set when a
+	// front panel displays it or a trace is being written.
+	decodeIR bool
+
+	// Trace output, one line per executed instruction
+	trace io.Writer
+
+	// Address the current instruction was fetched from
+	ia uint16
+
 	// Accumulator Register
 	AC uint16
 
 	// Link Flag [1-bit]
 	L bool
 
+	// Multiplier Quotient Register
+	MQ uint16
+
+	// EAE Step Counter [5-bit]
+	SC uint16
+
+	// Greater Than Flag (EAE mode B, saved and restored by GTF/RTF)
+	GT bool
+
 	// Memory Address Register
 	MA uint16
 
 	// Memory Buffer Register
 	MB uint16
 
-	// Memory [4K x 12 (int16)]
-	// Addresses 0o0 to 0o7777
-	MEM [4096]uint16
+	// Memory [4K x 12 (int16)] per field, 1 to 8 fields
+	// Addresses 0o00000 to 0o77777
+	MEM []uint16
+
+	// HD-6120 control panel memory, same layout as MEM.
+	// nil if no panel program has been loaded.
+	PMEM []uint16
+
+	// Predecoded instructions, one entry per memory location.
+	// Entries are invalidated when the location is written.
+	decoded      []decodedOp
+	decodedPanel []decodedOp
+
+	// Decode every instruction afresh, to measure what the cache saves
+	noDecodeCache bool
+
+	// Memory extension registers [3-bit]
+	// Instruction Field, Data Field and Instruction field Buffer (loaded by
+	// CIF, transferred to IF by the next JMP or JMS)
+	IF uint16
+	DF uint16
+	IB uint16
+
+	// Save Field [6-bit], IF and DF are saved here on interrupt
+	SF uint16
+
+	// Interrupt Inhibit, set by CIF until the next JMP or JMS
+	II bool
+
+	// Highest field number installed, fields wrap around past this
+	fieldMask uint16
+
+	// Field and memory (main or panel) of the address in MA
+	mf     uint16
+	mpanel bool
+
+	// HD-6120 stack pointers
+	SP1 uint16
+	SP2 uint16
+
+	// HD-6120 control panel state
+	PANEL struct {
+		// If CTRL is set, instructions are fetched from panel memory
+		CTRL bool
+
+		// Panel data flag, indirect data references go to panel memory
+		PDF bool
+
+		// Set by PEX, leave panel mode at the next JMP or JMS
+		PEX bool
+
+		// Panel status flags read by PRS
+		BTSTRP bool // Panel entered by an external request
+		PNLTRP bool // Panel entered by PR0-PR3
+		PWRON  bool // Panel entered on power up
+		HLTFLG bool // Panel entered by HLT
+	}
 
 	// Switch Register
-	// (unused)
 	SR uint16
 
+	// Set by the HD-6120 WSR instruction, SR then holds the value written by
+	// software rather than the front panel switches
+	srWritten bool
+
 	// The state structure holds the current state of the CPU
 	STATE struct {
 		// If halt is set, the computer is halted during the fetch phase
@@ -85,63 +167,70 @@ type MK12 struct {
 		// If SSTEP is set, the computer halts after every instruction
 		SSTEP bool
 
+		// If CSTEP is set as well, it also halts between fetch and execute
+		CSTEP bool
+
 		// If EXIT is set, the computer exits upon a HLT instruction
 		EXIT bool
+
+		// If IE is set, the interrupt system is on
+		IE bool
 	}
 
+	// Set while halted between the fetch and execute of an instruction
+	fetched bool
+
+	// Set by ION, holds off interrupts until the next instruction has executed
+	ieDelay bool
+
+	// Memory cycles taken by the current instruction
+	cycles int
+
+	// Memory cycles stolen by data breaks since the last throttle
+	stolen int
+
+	// Breakpoints, indexed by 15-bit address. nil if none have been set.
+	breakpoints []bool
+
 	// IOT is an array of IOT devices.
 	IOT []Device
 
+	// Device events waiting to run, in time order
+	events []event
+
 	// Front panel attached to this computer
 	fp FrontPanel
 
 	// The HW struct contains information about the simulated hardware
 	HW struct {
 		// F_CPU is the theoretical clock speed in Hz
+		// A speed of 0 runs the machine unthrottled
 		F_CPU int64
-	}
-}
 
-// This function handles the HALT state, listening for inputs
-func (mk *MK12) halt() {
-	// If EXIT flag is set, we exit upon a halt
-	if mk.STATE.EXIT {
-		// mk.fp.PowerOff()
-		return
-		// os.Exit(int(mk.AC))
-	}
+		// If REALTIME is set, the machine is paced to the instruction timing
+		// of the model instead of F_CPU
+		REALTIME bool
 
-	// Listen for keyboard inputs
-	// if !mk.STATE.SSTEP {
-	// debugPrint(mk.g, "** SYSTEM HALTED **  [ENTER] CONTINUE  |  [CTRL] + [C] EXIT  |  [SPACE] SINGLE STEP")
-	// updateStatus(mk.g, "HALT", gocui.AttrBold|gocui.ColorRed)
-	// } else {
-	// updateStatus(mk.g, "STEP", gocui.AttrBold|gocui.ColorBlue)
-	// }
+		// Processor model being simulated
+		MODEL CPUModel
 
-	for mk.STATE.HALT {
-		c := getLastKey()
-		switch c {
-		case '\n':
-			// debugPrint(mk.g, "Detected [ENTER]")
-			mk.STATE.SSTEP = false
-			mk.STATE.HALT = false
+		// Extended arithmetic element installed
+		EAE bool
 
-		case ' ':
-			// debugPrint(mk.g, "Detected [SPACE]")
-			mk.STATE.SSTEP = true
-			mk.STATE.HALT = false
+		// Number of instructions executed since power on
+		INSTRUCTIONS uint64
 
-		case 17: // Device Control 1 Loads the program counter with the switch register
-			mk.PC = mk.SR
+		// Memory cycles stolen by data breaks since power on
+		BREAKS uint64
 
-		case 0: // No keypress
-			fallthrough
-		default:
-			// Sleep for a bit - this solves the problem of high cpu usage
-			time.Sleep(time.Millisecond * 1)
-		}
+		// Simulated time since power on, the sum of the instruction and
+		// data break times
+		TIME time.Duration
 	}
+
+	// Wall clock time and simulated time the realtime throttle started from
+	realStart time.Time
+	realBase  time.Duration
 }
 
 // This function implements the fetch process:
@@ -154,12 +243,17 @@ func (mk *MK12) halt() {
 //  5. Fetches the Content of the Effective Address (CA) for instructions that require an operand
 func (mk *MK12) fetch() {
 
-	// Check if step button pressed
-	if c := getLastKey(); c == ' ' {
-		mk.STATE.SSTEP = true
-	} // else if c == 17 { // or if home was pressed
-	// 	mk.PC = mk.SR
-	// }
+	// Check for HALT or single step pressed while running
+	if key := mk.fp.ReadKey(); key != KEY_NONE {
+		mk.operate(key)
+	}
+	mk.pollCommand()
+
+	// Halt before executing an instruction with a breakpoint on it
+	if mk.breakpoints != nil && !mk.STATE.HALT && !mk.PANEL.CTRL && mk.breakpoints[mk.IF<<12|mk.PC] {
+		mk.STATE.HALT = true
+		mk.fp.Print(fmt.Sprintf("Breakpoint at %05o", mk.IF<<12|mk.PC))
+	}
 
 	// Set HALT if single stepping
 	if mk.STATE.SSTEP {
@@ -181,39 +275,56 @@ func (mk *MK12) fetch() {
 	// Save PC into MB for later use (indirect addressing)
 	mk.MA = mk.PC
 	mk.MB = mk.PC
+	mk.ia = mk.PC
 
 	// Increment PC to point to the next instruction to execute
 	mk.PC = (mk.PC + 1) % 4096
+	mk.cycles = 1
 
-	// Load instruction register
-	mk.IR = mk.MEM[mk.MA]
+	// Load instruction register from the instruction field
+	mem := mk.imem()
+	mk.IR = mem[mk.IF<<12|mk.MA]
 	mk.IRd = ""
 
-	// Shorthand variable for the current instruction operator
-	inOpr := mk.IR >> 9
+	// Look up the predecoded instruction, this has the effective address of
+	// memory reference instructions already worked out
+	op := mk.decode(mk.IF<<12 | mk.MA)
+
 	// Load correct address and/or operand for memory reference instructions
-	if inOpr <= JMP {
-		var addr uint16
-		if (mk.IR & 0b0000000010000000) > 0 {
-			// If page bit is set, we use the current page
-			addr = mk.MB & 0b0000111110000000
-		} else {
-			// If bit is not set, we use the first page
-			addr = 0
-		}
-		// Fill in word address in page
-		addr = addr | (mk.IR & 0b0000000001111111)
+	if op.op <= JMP {
+		addr := op.addr
+		mk.mf = mk.IF
+		mk.mpanel = mk.PANEL.CTRL
 
 		// Check if indirect bit is set
-		if (mk.IR & 0b0000000100000000) > 0 {
+		if op.indirect {
+			mk.cycles++
+
+			// The pointer is always in the instruction field
+			ptr := mk.IF<<12 | addr
 
 			// Auto increment addresses 0o10 0o17
-			if (addr >= AUTO_begin) && (addr <= AUTO_end) {
-				mk.MEM[addr], _ = MKadd(mk.MEM[addr], 1)
+			if op.auto {
+				inc, _ := MKadd(mem[ptr], 1)
+				mk.writeMem(mk.PANEL.CTRL, ptr, inc)
 			}
 
 			// Get address stored at addr
-			addr = mk.MEM[addr]
+			addr = mem[ptr]
+
+			// Indirect data references go to the data field, and from panel
+			// mode to main memory unless the panel data flag is set
+			if op.op < JMS {
+				mk.mf = mk.DF
+				mk.mpanel = mk.PANEL.CTRL && mk.PANEL.PDF
+			}
+		}
+
+		// Jumps go to the field in the instruction field buffer, and out of
+		// panel memory if the panel is exiting
+		if op.op == JMS || op.op == JMP {
+			mk.mf = mk.IB
+			mk.mpanel = mk.PANEL.CTRL && !mk.PANEL.PEX
 		}
 
 		// Store address in MA
@@ -221,8 +332,13 @@ func (mk *MK12) fetch() {
 	}
 
 	// Load data from address for data reference instructions
-	if inOpr == AND || inOpr == TAD || inOpr == ISZ {
-		mk.MB = mk.MEM[mk.MA]
+	if op.op == AND || op.op == TAD || op.op == ISZ {
+		mk.MB = mk.load()
+	}
+
+	// Everything but JMP takes an execute cycle
+	if op.op < JMP {
+		mk.cycles++
 	}
 }
 
@@ -234,90 +350,103 @@ func (mk *MK12) execute() {
 	case AND:
 		// AND data with AC and store it back in AC
 		tAC := mk.AC & mk.MB
-		mk.IRd = fmt.Sprintf("AND %o & %o = %o --> AC", mk.AC, mk.MB, tAC)
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("AND %o & %o = %o --> AC", mk.AC, mk.MB, tAC)
+		}
 		mk.AC = tAC
 
 	case TAD:
 		tAC, c := MKadd(mk.AC, mk.MB)
-		mk.IRd = fmt.Sprintf("TAD %o + %o = %o --> AC", mk.AC, mk.MB, tAC)
-		mk.L = c
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("TAD %o + %o = %o --> AC", mk.AC, mk.MB, tAC)
+		}
+		// A carry out of AC complements the link
+		mk.L = mk.L != c
 		mk.AC = tAC
 
 	case ISZ:
 		// Increment MB and store it in MEM
 		mk.MB, _ = MKadd(mk.MB, 1)
-		mk.MEM[mk.MA] = mk.MB
+		mk.store(mk.MB)
 		// If MB is zero, skip next instruction
 		if mk.MB == 0 {
-			mk.IRd = fmt.Sprintf("ISZ %o + 1 = %o --> %o; SKP %o", mk.MB-1, mk.MB, mk.MA, mk.PC)
-			mk.PC = mk.PC + 1
-		} else {
+			if mk.decodeIR {
+				mk.IRd = fmt.Sprintf("ISZ %o + 1 = %o --> %o; SKP %o", mk.MB-1, mk.MB, mk.MA, mk.PC)
+			}
+			mk.PC = (mk.PC + 1) % 4096
+		} else if mk.decodeIR {
 			mk.IRd = fmt.Sprintf("ISZ %o + 1 = %o --> %o", mk.MB-1, mk.MB, mk.MA)
 		}
 
 	case DCA:
 		mk.MB = mk.AC
-		mk.MEM[mk.MA] = mk.MB
+		mk.store(mk.MB)
 		mk.AC = 0
-		mk.IRd = fmt.Sprintf("DCA %o --> %o ; 0 --> AC", mk.MB, mk.MA)
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("DCA %o --> %o ; 0 --> AC", mk.MB, mk.MA)
+		}
 
 	case JMS:
-		mk.MEM[mk.MA] = mk.PC
-		mk.IRd = fmt.Sprintf("JMS %o ; RET %o", mk.MA, mk.PC)
-		mk.PC = mk.MA + 1
+		mk.store(mk.PC)
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("JMS %o ; RET %o", mk.MA, mk.PC)
+		}
+		mk.PC = (mk.MA + 1) % 4096
+		mk.jump()
 
 	case JMP:
 		// Jump to the address stored in MA by storing it in the PC
 		mk.PC = mk.MA
-		mk.IRd = fmt.Sprintf("JMP %o", mk.MA)
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("JMP %o", mk.MA)
+		}
+		mk.jump()
 
 	case IOT:
 		devAddr := (mk.IR >> 3) & 0o77
 		op1 := mk.IR & 0b001
 		op2 := (mk.IR & 0b010) >> 1
 		op4 := (mk.IR & 0b100) >> 2
-		mk.IRd = fmt.Sprintf("IOT %.3o %.3b", devAddr, op1|op2|op4)
+		if mk.decodeIR {
+			mk.IRd = fmt.Sprintf("IOT %.3o %.3b", devAddr, mk.IR&0o7)
+		}
+		mk.cycles += mk.HW.MODEL.IOTCycles
+
+		if devAddr == CPU_IOT {
+			if !mk.PANEL.CTRL || !mk.panelIOT() {
+				mk.processorIOT()
+			}
+			break
+		}
+		if devAddr >= MEM_IOT_begin && devAddr <= MEM_IOT_end && mk.hasMemoryExtension() {
+			if !mk.HW.MODEL.HD6120 || !mk.hd6120IOT() {
+				mk.memoryIOT()
+			}
+			break
+		}
 
 		for _, dev := range mk.IOT {
 			if dev.Select(devAddr, mk) {
+				// Devices that decode the whole function code
+				if d, ok := dev.(IOTDecoder); ok {
+					skip, clr, or := d.Operate(mk.IR & 0o7)
+					mk.ioSignals(dev, skip, clr, or)
+					break
+				}
 				// IOP1
 				if op1 == 1 {
 					skip, clr, or := dev.Iop1()
-					if skip {
-						mk.PC += 1 // Skip next instruction
-					}
-					if clr {
-						mk.AC = 0 // Clear AC
-					}
-					if or {
-						mk.AC |= dev.Get() // OR AC with device input
-					}
+					mk.ioSignals(dev, skip, clr, or)
 				}
 				// IOP2
 				if op2 == 1 {
 					skip, clr, or := dev.Iop2()
-					if skip {
-						mk.PC += 1 // Skip next instruction
-					}
-					if clr {
-						mk.AC = 0 // Clear AC
-					}
-					if or {
-						mk.AC |= dev.Get() // OR AC with device input
-					}
+					mk.ioSignals(dev, skip, clr, or)
 				}
 				// IOP4
 				if op4 == 1 {
 					skip, clr, or := dev.Iop4()
-					if skip {
-						mk.PC += 1 // Skip next instruction
-					}
-					if clr {
-						mk.AC = 0 // Clear AC
-					}
-					if or {
-						mk.AC |= dev.Get() // OR AC with device input
-					}
+					mk.ioSignals(dev, skip, clr, or)
 				}
 				break
 			}
@@ -325,7 +454,7 @@ func (mk *MK12) execute() {
 
 	case OPR:
 		// We wait a millisecond for a NOP instruction.
-		if mk.IR == 0o7000 {
+		if mk.IR == 0o7000 && mk.HW.F_CPU != 0 {
 			time.Sleep(time.Millisecond)
 		}
 
@@ -336,119 +465,74 @@ func (mk *MK12) execute() {
 
 		switch group {
 		case OPR_GROUP_1:
-			var debugInst string = "OPR "
-
-			if ((mk.IR >> 7) & 1) == 1 { // CLA - Clear Accumulator
-				mk.AC = 0
-				debugInst += "CLA "
-			}
-			if ((mk.IR >> 6) & 1) == 1 { // CLL - Clear Link
-				mk.L = false
-				debugInst += "CLL "
-			}
-
-			if ((mk.IR >> 5) & 1) == 1 { // CMA - Complement Accumulator
-				mk.AC = MKcomplement(mk.AC)
-				debugInst += "CMA "
-			}
-			if ((mk.IR >> 4) & 1) == 1 { // CML - Complement Link
-				if mk.L {
-					mk.L = false
-				} else {
-					mk.L = true
-				}
-				debugInst += "CML "
-			}
-
-			if ((mk.IR) & 1) == 1 { // IAC - Increment Accumulator
-				mk.AC, mk.L = MKadd(mk.AC, 1)
-				debugInst += "IAC "
+			mk.group1()
+			if mk.decodeIR {
+				mk.IRd = "OPR " + oprMnemonics(mk.IR)
 			}
 
-			if ((mk.IR >> 1) & 1) == 1 { // Rotate twice
-				if ((mk.IR >> 3) & 1) == 1 { // RTR
-					mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
-					mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
-					debugInst += "RTR"
-				}
-				if ((mk.IR >> 2) & 1) == 1 { // RTL
-					mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
-					mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
-					debugInst += "RTL"
-				}
-
-			} else { // Single rotate
-				if ((mk.IR >> 3) & 1) == 1 { // RAR
-					mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
-					debugInst += "RAR"
-				}
-				if ((mk.IR >> 2) & 1) == 1 { // RAL
-					mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
-					debugInst += "RAL"
-				}
-			}
-
-			mk.IRd = debugInst
-
 		case OPR_GROUP_2:
-			var debugInst string = "OPR "
-			if ((mk.IR >> 7) & 1) == 1 { // CLA - Clear AC
-				mk.AC = 0
-				debugInst += "CLA "
-			}
-
-			// Determine state of skip conditions
-			debugInst += "("
+			// Determine state of skip conditions, these are sensed before CLA
 			skip := false
+			skipped := false
 			if ((mk.IR >> 6) & 1) == 1 { // SMA - Skip on AC < 0
-				if mk.AC < 0 || (mk.AC&0o4000) > 0 {
+				if (mk.AC & 0o4000) > 0 {
 					skip = true
 				}
-				debugInst += "SMA "
 			}
 			if ((mk.IR >> 5) & 1) == 1 { // SZA - Skip on AC == 0
 				if mk.AC == 0 {
 					skip = true
 				}
-				debugInst += "SZA "
 			}
 			if ((mk.IR >> 4) & 1) == 1 { // SNL - Skip on L == 1
 				if mk.L {
 					skip = true
 				}
-				debugInst += "SNL "
 			}
-			debugInst += ")"
 			// Do the actual skip
 			if ((mk.IR >> 3) & 1) == 1 { // Sense of skip (any or none)
 				// If bit is set, no skip occurs if any condition has been satisfied (skip=true)
 				if !skip {
-					mk.PC = mk.PC + 1
-					debugInst += "SKIP[NOR]"
+					mk.PC = (mk.PC + 1) % 4096
+					skipped = true
 				}
 			} else {
 				// If bit is not set, skip occurs if any condition is satisfied
 				if skip {
-					debugInst += "SKIP[OR]"
-					mk.PC = mk.PC + 1
+					mk.PC = (mk.PC + 1) % 4096
+					skipped = true
 				}
 			}
-			debugInst += ")"
+
+			if ((mk.IR >> 7) & 1) == 1 { // CLA - Clear AC
+				mk.AC = 0
+			}
 
 			if ((mk.IR >> 2) & 1) == 1 { // OSR - OR switch register with AC
 				mk.AC |= mk.SR
-				debugInst += "OSR "
 			}
 			if ((mk.IR >> 1) & 1) == 1 { // HLT - Halt the system
-				debugInst += "HALT"
-				mk.STATE.HALT = true
+				if mk.HW.MODEL.HD6120 && mk.PMEM != nil && !mk.PANEL.CTRL {
+					// The HD-6120 traps to the control panel instead
+					mk.PANEL.HLTFLG = true
+					mk.panelTrap()
+				} else {
+					mk.STATE.HALT = true
+				}
 			}
 
-			mk.IRd = debugInst
+			if mk.decodeIR {
+				mk.IRd = "OPR " + oprMnemonics(mk.IR)
+				if skipped {
+					mk.IRd += " ; SKIP"
+				}
+			}
 
 		case OPR_GROUP_3:
-			fmt.Fprintf(os.Stderr, "ERROR: group 3 operate instructions not implemented!\ninstruction: %04o\n", mk.IR)
-			os.Exit(1)
+			mk.group3()
+			if mk.decodeIR {
+				mk.IRd = "OPR " + eaeMnemonics(mk.IR)
+			}
 		}
 
 	default:
@@ -457,6 +541,99 @@ func (mk *MK12) execute() {
 	}
 }
 
+// Acts on the control signals a device returns for an IOT
+func (mk *MK12) ioSignals(dev Device, skip bool, clr bool, or bool) {
+	if skip {
+		mk.PC = (mk.PC + 1) % 4096 // Skip next instruction
+	}
+	if clr {
+		mk.AC = 0 // Clear AC
+	}
+	if or {
+		mk.AC |= dev.Get() // OR AC with device input
+	}
+}
+
+// Group 1 operate microinstructions, applied in the logical sequence of the
+// selected model:
+//  1. CLA CLL
+//  2. CMA CML
+//  3. IAC
+//  4. RAR RAL RTR RTL BSW
+//
+// On the original PDP-8 IAC shares step 4 with the rotates.
+func (mk *MK12) group1() {
+	model := &mk.HW.MODEL
+
+	if ((mk.IR >> 7) & 1) == 1 { // CLA - Clear Accumulator
+		mk.AC = 0
+	}
+	if ((mk.IR >> 6) & 1) == 1 { // CLL - Clear Link
+		mk.L = false
+	}
+
+	if ((mk.IR >> 5) & 1) == 1 { // CMA - Complement Accumulator
+		mk.AC = MKcomplement(mk.AC)
+	}
+	if ((mk.IR >> 4) & 1) == 1 { // CML - Complement Link
+		mk.L = !mk.L
+	}
+
+	rotate := (mk.IR >> 1) & 0o7 // RAR RAL BSW bits
+	if (mk.IR & 1) == 1 {        // IAC - Increment Accumulator
+		if model.IACRotate || rotate&0o6 == 0 {
+			var c bool
+			mk.AC, c = MKadd(mk.AC, 1)
+			// A carry out of AC complements the link
+			mk.L = mk.L != c
+		}
+	}
+
+	switch rotate {
+	case 0o1: // BSW - Byte swap
+		if model.BSW {
+			mk.AC = ((mk.AC & 0o77) << 6) | (mk.AC >> 6)
+		}
+	case 0o2: // RAL
+		mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
+	case 0o3: // RTL
+		mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
+		mk.AC, mk.L = MKrotateLeft(mk.AC, mk.L)
+	case 0o4: // RAR
+		mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
+	case 0o5: // RTR
+		mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
+		mk.AC, mk.L = MKrotateRight(mk.AC, mk.L)
+	case 0o6, 0o7: // RAR RAL, RTR RTL - undefined
+		mk.rotateUndefined(rotate == 0o7)
+	}
+}
+
+// Handles the rotate combinations DEC never defined
+func (mk *MK12) rotateUndefined(twice bool) {
+	switch mk.HW.MODEL.RotateUndef {
+	case ROT_UNDEF_8E:
+		if twice {
+			mk.AC = (mk.ia & 0o7600) | (mk.IR & 0o177)
+		} else {
+			mk.AC &= mk.IR
+		}
+	case ROT_UNDEF_6120:
+		if !twice { // R3L
+			mk.AC = ((mk.AC << 3) | (mk.AC >> 9)) & 0o7777
+		}
+	default:
+		left, ll := MKrotateLeft(mk.AC, mk.L)
+		right, rl := MKrotateRight(mk.AC, mk.L)
+		if twice {
+			left, ll = MKrotateLeft(left, ll)
+			right, rl = MKrotateRight(right, rl)
+		}
+		mk.AC = left | right
+		mk.L = ll || rl
+	}
+}
+
 func (mk *MK12) run() {
 	// Init the registers with some default value because we get stuck in the first fetch halt loop
 	mk.fp.Update(*mk)
@@ -469,16 +646,80 @@ func (mk *MK12) run() {
 		}
 		// Update SR after we fetch because we might be returning from a HALT, so
 		// the switches might have changed. Update it before execute for same reason
-		mk.SR = mk.fp.ReadSwitches()
+		if !mk.srWritten {
+			mk.SR = mk.fp.ReadSwitches()
+		}
 		mk.fp.Update(*mk)
+		mk.cycleStep()
 
 		mk.execute()
+		mk.retire()
 
 		mk.fp.Update(*mk)
 
-		if !mk.STATE.SSTEP {
-			time.Sleep(((time.Duration(mk.HW.F_CPU / 1000000)) * time.Millisecond))
+		mk.throttle()
+	}
+}
+
+// runFast is run without the per-instruction front panel updates. It is used
+// with front panels that don't display anything while the machine is running.
+func (mk *MK12) runFast() {
+	for {
+		mk.fetch()
+		if mk.STATE.HALT && mk.STATE.EXIT {
+			break
 		}
+		if !mk.srWritten {
+			mk.SR = mk.fp.ReadSwitches()
+		}
+		mk.cycleStep()
+		mk.execute()
+		mk.retire()
+		mk.throttle()
+	}
+	mk.fp.Update(*mk)
+}
+
+// Bookkeeping done after every executed instruction, this is also where
+// device events are run and pending interrupts are taken
+func (mk *MK12) retire() {
+	mk.HW.INSTRUCTIONS++
+	mk.HW.TIME += time.Duration(mk.cycles) * mk.HW.MODEL.Cycle
+	if mk.trace != nil {
+		fmt.Fprintf(mk.trace, "%04o  %04o  %s\n", mk.ia, mk.IR, mk.IRd)
+	}
+	if len(mk.events) > 0 {
+		mk.runEvents()
+	}
+	mk.interrupt()
+}
+
+// Slows the machine down to the configured clock speed, or with REALTIME set
+// to the speed of the real machine
+func (mk *MK12) throttle() {
+	stolen := mk.stolen
+	mk.stolen = 0
+	if mk.STATE.SSTEP {
+		// Start pacing over once we are running again
+		mk.realStart = time.Time{}
+		return
+	}
+	if mk.HW.REALTIME {
+		if mk.realStart.IsZero() {
+			mk.realStart = time.Now()
+			mk.realBase = mk.HW.TIME
+		}
+		// Sleeping is coarse, so only catch up once we're a few ms ahead
+		ahead := (mk.HW.TIME - mk.realBase) - time.Since(mk.realStart)
+		if ahead > 2*time.Millisecond {
+			time.Sleep(ahead)
+		}
+		return
+	}
+	if mk.HW.F_CPU != 0 {
+		// Cycles stolen by data breaks slow the processor down as well
+		d := time.Duration(mk.HW.F_CPU/1000000) * time.Millisecond
+		time.Sleep(d + d*time.Duration(stolen)/time.Duration(mk.cycles))
 	}
 }
 
@@ -489,73 +730,284 @@ func main() {
 	// Create a new MK-12 computer and configure needed flags for startup
 	myMK12 := MK12{}
 	myMK12.HW.F_CPU = args.F_CPU
+	myMK12.HW.REALTIME = args.Realtime
 	myMK12.STATE.HALT = args.HALT
 	myMK12.STATE.EXIT = args.EXIT
 
-	// Create our front panel
+	// Select the processor model
+	model, err := LookupCPUModel(args.Model)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	myMK12.HW.MODEL = model
+	if args.EAE {
+		if !model.EAE {
+			fmt.Fprintf(os.Stderr, "ERROR: EAE is not available on the %s\n", model.Description)
+			os.Exit(1)
+		}
+		myMK12.HW.EAE = true
+	}
+
+	// Teletype personality, for the console and every other serial line
+	personality, err := LookupPersonality(args.TTY)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	if args.Clock < 0 || args.Clock > 1000000 {
+		fmt.Fprintf(os.Stderr, "ERROR: clock rate %d Hz is not 1-1000000\n", args.Clock)
+		os.Exit(1)
+	}
+
+	// Install memory, models with the field registers on chip default to 32K
+	memory := args.Memory
+	if memory == 0 {
+		memory = 4
+		if model.Fields {
+			memory = 32
+		}
+	}
+	if err := myMK12.installMemory(memory); err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Load the control panel program into panel memory
+	if args.Panel != "" {
+		if !model.HD6120 {
+			fmt.Fprintf(os.Stderr, "ERROR: control panel memory needs the 6120 model\n")
+			os.Exit(1)
+		}
+		pm, err := LoadObjectFile(args.Panel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		myMK12.PMEM = make([]uint16, len(myMK12.MEM))
+		copy(myMK12.PMEM, pm[:])
+	}
+
+	// Open the instruction trace
+	if args.Trace != "" {
+		traceFile, err := os.Create(args.Trace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		// Deferred calls don't run on os.Exit, so the trace is flushed once the
+		// machine stops running
+		traceWriter := bufio.NewWriter(traceFile)
+		defer traceFile.Close()
+		myMK12.trace = traceWriter
+		myMK12.decodeIR = true
+	}
+
+	// The teletype can be served over telnet, before the front panel takes
+	// over the terminal
+	var keyboard TeleTypeKeyboard
+	var printer TeleTypePrinter
+	if args.Telnet != "" {
+		tn, err := NewTelnetTerminal(args.Telnet)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		keyboard, printer = tn, tn
+	}
+
+	// Create our front panel, the web and curses panels have a teletype of
+	// their own
 	if args.NoGui {
 		myMK12.fp = new(CLIFrontPanel)
 		myMK12.fp.PowerOn(myMK12)
-		// Setup IOT Teleprinter to stdin/stdout
-		teleType := TeleTypeDevice{
-			Keyboard: NewStdinKeyboard(),
-			Printer:  bufio.NewWriter(os.Stdout),
+	} else if args.Web != "" {
+		wfp := &WebFrontPanel{Addr: args.Web, Files: args.WebFiles}
+		myMK12.fp = wfp
+		myMK12.fp.PowerOn(myMK12)
+		// The teletype is the terminal on the web page
+		if keyboard == nil {
+			keyboard = &WebKeyboard{fp: wfp}
+			printer = &WebTeleprinter{fp: wfp}
 		}
-		myMK12.IOT = append(myMK12.IOT, &teleType)
 	} else {
 		cfp := new(CUIFrontPanel)
 		cfp.MemoryViewerPage = args.Page
 		myMK12.fp = cfp
+		// The debug console shows the decoded instruction
+		myMK12.decodeIR = true
 		// Power up the front panel
 		myMK12.fp.PowerOn(myMK12)
-		ctele := CursedTeleprinter{g: cfp.g}
-		teleType := TeleTypeDevice{
-			Keyboard: NewStdinKeyboard(),
-			Printer:  &ctele,
+		if printer == nil {
+			printer = &CursedTeleprinter{g: cfp.g}
 		}
-		myMK12.IOT = append(myMK12.IOT, &teleType)
 	}
 
-	// Create our papertape reader/punch
-	// infile, er := os.OpenFile(args.iTapeFile, os.O_CREATE|os.O_RDONLY, 0644)
-	// if er != nil {
-	// 	panic(er)
-	// }
-	// Append to out file
-	// outfile, er := os.OpenFile(args.oTapeFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	// if er != nil {
-	// 	panic(er)
-	// }
-	// Defer closing papertape files
-	// defer infile.Close()
-	// defer outfile.Close()
-	// paperTape := PaperTapeDevice{
-	// 	inTape:  infile,
-	// 	outTape: outfile,
-	// }
-	// myMK12.IOT = append(myMK12.IOT, &paperTape)
+	// Setup IOT Teleprinter, otherwise to stdin/stdout
+	if keyboard == nil {
+		keyboard = NewStdinKeyboard()
+	}
+	if printer == nil {
+		printer = bufio.NewWriter(os.Stdout)
+	}
+	myMK12.IOT = append(myMK12.IOT, NewTeleTypeDevice(TT_KEYBOARD, keyboard, printer))
+
+	// More serial lines
+	if err := myMK12.attachLines(args.KL8E); err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	for _, dev := range myMK12.IOT {
+		if tt, ok := dev.(*TeleTypeDevice); ok {
+			tt.Personality = personality
+		}
+	}
+
+	// Parallel I/O
+	if err := myMK12.attachDR8s(args.DR8); err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
 
-	// Load our compiled object file, basing the format off the extension
+	// Real-time clock
+	if args.Clock > 0 {
+		myMK12.IOT = append(myMK12.IOT, NewRealTimeClock(&myMK12, args.Clock, args.ClockWall))
+	}
+
+	// Mass storage, and the plotter which is attached like it
+	if err := setPlotter(args.PlotStep, args.PlotPaper); err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	if err := myMK12.attachImages(args.Attach); err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Paper tape reader and punch
+	paperTape := NewPaperTape()
+	myMK12.IOT = append(myMK12.IOT, paperTape)
+	if args.Tape != "" {
+		err = paperTape.Load(args.Tape)
+	}
+	if err == nil && args.Punch != "" {
+		err = paperTape.Punch(args.Punch)
+	}
+	if err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Line printer
+	var lpt *LinePrinter
+	if args.LPT != "" {
+		lpt, err = NewLinePrinter(args.LPT, args.LPTColumns, args.LPTLines, args.LPTGreenBar)
+		if err != nil {
+			myMK12.fp.PowerOff()
+			fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			os.Exit(1)
+		}
+		myMK12.IOT = append(myMK12.IOT, lpt)
+	}
+
+	// Point plot display
+	if args.VC8 || args.VC8Frames != "" {
+		vc := NewVC8(&myMK12, args.VC8Decay)
+		myMK12.IOT = append(myMK12.IOT, vc)
+		if args.VC8Frames != "" {
+			if args.VC8FPS < 1 {
+				err = fmt.Errorf("-vc8-fps must be at least 1")
+			} else {
+				err = vc.RecordFrames(args.VC8Frames, args.VC8FPS)
+			}
+			if err != nil {
+				myMK12.fp.PowerOff()
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	// Devices simulated by plugins
+	if err := myMK12.attachPlugins(args.Plugins); err != nil {
+		myMK12.fp.PowerOff()
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Load our compiled object file into field 0
 	var m [4096]uint16
-	var err error
-	switch path.Ext(args.InFile) {
-	case ".rim":
-		m, err = LoadRIMFile(args.InFile)
-	case ".po":
-		fallthrough
-	default:
-		m, err = LoadPObjFile(args.InFile)
+	if args.InFile != "" {
+		m, err = LoadObjectFile(args.InFile)
 	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "ERROR: Unknown input file")
 		myMK12.AC = 1
 	} else {
-		myMK12.MEM = m
+		copy(myMK12.MEM, m[:])
+		myMK12.invalidate()
 
 		// Set PC to RESET vector and start computer
 		myMK12.PC = 0o200
-		myMK12.run()
+		// Or boot from a drive, which replaces the program's start
+		if args.Boot != "" {
+			if err := myMK12.boot(args.Boot); err != nil {
+				myMK12.fp.PowerOff()
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		// Or start a built-in loader
+		if args.Loader != "" {
+			if _, err := myMK12.loader(args.Loader); err != nil {
+				myMK12.fp.PowerOff()
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		// With a panel program loaded the HD-6120 powers up in panel mode
+		if myMK12.PMEM != nil {
+			myMK12.PANEL.PWRON = true
+			myMK12.panelTrap()
+		}
+		start := time.Now()
+		if args.NoGui {
+			myMK12.runFast()
+		} else {
+			myMK12.run()
+		}
+		elapsed := time.Since(start)
 		myMK12.fp.PowerOff()
+		myMK12.closeLines()
+		myMK12.closeDR8s()
+		myMK12.closePlugins()
+		myMK12.detachAll()
+		paperTape.Close()
+		if lpt != nil {
+			lpt.Close()
+		}
+		if vc := myMK12.vc8(); vc != nil {
+			if err := vc.Close(); err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+			}
+		}
+		if tw, ok := myMK12.trace.(*bufio.Writer); ok {
+			tw.Flush()
+		}
+
+		if args.Stats {
+			fmt.Fprintf(os.Stderr, "%d instructions in %v (%.0f instructions/s)\n",
+				myMK12.HW.INSTRUCTIONS, elapsed, float64(myMK12.HW.INSTRUCTIONS)/elapsed.Seconds())
+			if myMK12.HW.BREAKS > 0 {
+				fmt.Fprintf(os.Stderr, "%d memory cycles stolen by data breaks\n", myMK12.HW.BREAKS)
+			}
+		}
 	}
 
 	if args.Return {
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a PDP-8/E with 32K of memory that exits when it halts
+func newTestMK12(t testing.TB) *MK12 {
+	mk := &MK12{}
+	model, err := LookupCPUModel("8e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mk.HW.MODEL = model
+	if err := mk.installMemory(32); err != nil {
+		t.Fatal(err)
+	}
+	mk.fp = new(CLIFrontPanel)
+	mk.STATE.EXIT = true
+	return mk
+}
+
+// Runs the machine until it halts or has executed limit instructions,
+// returning false if it didn't halt
+func runTest(mk *MK12, limit int) bool {
+	for i := 0; i < limit; i++ {
+		mk.fetch()
+		if mk.STATE.HALT && mk.STATE.EXIT {
+			return true
+		}
+		mk.execute()
+		mk.retire()
+	}
+	return false
+}
+
+// Gives dev an IOT with AC set to ac, returning whether it skipped
+func testIOT(mk *MK12, dev Device, code uint16, fn uint16, ac uint16) bool {
+	mk.AC = ac
+	pc := mk.PC
+	if !dev.Select(code, mk) {
+		return false
+	}
+	skip, clr, or := dev.(IOTDecoder).Operate(fn)
+	mk.ioSignals(dev, skip, clr, or)
+	mk.PC = pc
+	return skip
+}
+
+// Lets simulated time pass until done returns true, failing the test if it
+// doesn't within limit
+func waitTest(t testing.TB, mk *MK12, limit time.Duration, done func() bool) {
+	t.Helper()
+	for end := mk.HW.TIME + limit; !done(); mk.HW.TIME += 10 * time.Microsecond {
+		if mk.HW.TIME > end {
+			t.Fatalf("still waiting after %v", limit)
+		}
+		mk.runEvents()
+	}
+}
+
+// A loop of memory reference instructions, an auto-index read and a
+// subroutine call, run 4096 times before it halts
+var benchLoop = []uint16{
+	0o7300, // 200       CLA CLL
+	0o3221, // 201       DCA CNT
+	0o1410, // 202 LOOP, TAD I 10
+	0o3225, // 203       DCA TMP
+	0o4222, // 204       JMS SUB
+	0o2221, // 205       ISZ CNT
+	0o5202, // 206       JMP LOOP
+	0o7402, // 207       HLT
+	0o0000, // 210
+	0o0000, // 211
+	0o0000, // 212
+	0o0000, // 213
+	0o0000, // 214
+	0o0000, // 215
+	0o0000, // 216
+	0o0000, // 217
+	0o0000, // 220
+	0o0000, // 221 CNT,  0
+	0o0000, // 222 SUB,  0
+	0o7001, // 223       IAC
+	0o5622, // 224       JMP I SUB
+	0o0000, // 225 TMP,  0
+}
+
+// Runs the loop headless with runFast, as -no-gui -exit would
+func benchmarkRun(b *testing.B, noDecodeCache bool) {
+	mk := &MK12{}
+	if err := mk.installMemory(4); err != nil {
+		b.Fatal(err)
+	}
+	mk.fp = new(CLIFrontPanel)
+	mk.STATE.EXIT = true
+	mk.noDecodeCache = noDecodeCache
+	for i, w := range benchLoop {
+		mk.write(0o200+uint16(i), w)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		mk.PC = 0o200
+		mk.STATE.HALT = false
+		mk.runFast()
+	}
+	b.ReportMetric(float64(mk.HW.INSTRUCTIONS)/float64(b.N), "instructions/op")
+}
+
+func BenchmarkRunDecodeCache(b *testing.B) {
+	benchmarkRun(b, false)
+}
+
+func BenchmarkRunNoDecodeCache(b *testing.B) {
+	benchmarkRun(b, true)
+}
+
+// A carry out of AC complements the link rather than setting it, and no carry
+// leaves the link alone
+func TestTADLink(t *testing.T) {
+	for _, tc := range []struct {
+		l    bool
+		a, b uint16
+		ac   uint16
+		want bool
+	}{
+		{false, 0o0001, 0o0002, 0o0003, false},
+		{true, 0o0001, 0o0002, 0o0003, true},
+		{false, 0o7777, 0o0001, 0o0000, true},
+		{true, 0o7777, 0o0001, 0o0000, false},
+		{true, 0o4000, 0o4000, 0o0000, false},
+	} {
+		mk := newTestMK12(t)
+		for i, w := range []uint16{
+			0o7300, // 200       CLA CLL
+			0o1206, // 201       TAD A
+			0o1207, // 202       TAD B
+			0o7402, // 203       HLT
+			0o0000, // 204
+			0o0000, // 205
+			tc.a,   // 206 A,
+			tc.b,   // 207 B,
+		} {
+			mk.write(0o200+uint16(i), w)
+		}
+		mk.PC = 0o200
+		runTest(mk, 1)
+		mk.L = tc.l
+		if !runTest(mk, 10) {
+			t.Fatalf("didn't halt")
+		}
+		if mk.AC != tc.ac || mk.L != tc.want {
+			t.Errorf("L %v, %04o + %04o gave AC %04o L %v, want AC %04o L %v", tc.l, tc.a, tc.b, mk.AC, mk.L, tc.ac, tc.want)
+		}
+	}
+}
+
+// The 8/E's RTR RTL loads AC with the page of the instruction's address and
+// the word bits of the instruction
+func TestRotateUndefined8E(t *testing.T) {
+	mk := newTestMK12(t)
+	mk.write(0o5232, 0o7016) // RTR RTL
+	mk.write(0o5233, 0o7402) // HLT
+	mk.PC = 0o5232
+	mk.AC = 0o1234
+	if !runTest(mk, 10) {
+		t.Fatalf("didn't halt")
+	}
+	if mk.AC != 0o5216 {
+		t.Errorf("AC is %04o, want 5216", mk.AC)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// What the processor does with the rotate combinations that DEC left
+// undefined (RAR RAL and RTR RTL in the same group 1 instruction)
+const (
+	// Both rotations are gated onto the major register bus at once, so the
+	// results are ORed together
+	ROT_UNDEF_OR = iota
+	// PDP-8/E: RAR RAL ANDs the instruction into AC, RTR RTL loads AC with
+	// the page bits of the instruction's address and the word bits of the
+	// instruction
+	ROT_UNDEF_8E
+	// HD-6120: RAR RAL is R3L (rotate AC three places left, link untouched)
+	ROT_UNDEF_6120
+)
+
+// A CPUModel describes the parts of the instruction set that differ between
+// the historical PDP-8 implementations.
+type CPUModel struct {
+	// Name used to select the model with -model
+	Name string
+
+	// Long name for messages
+	Description string
+
+	// IAC happens in a sequence step before the rotates, so they can be
+	// combined (IAC RAL). Without this IAC and the rotates share a step and
+	// the increment is lost.
+	IACRotate bool
+
+	// 7002 (rotate twice with no direction) swaps the 6-bit halves of AC
+	BSW bool
+
+	// Behaviour of the undefined rotate combinations
+	RotateUndef int
+
+	// MQ register and the MQA/MQL group 3 microinstructions are standard
+	MQ bool
+
+	// Extended arithmetic element can be installed (-eae)
+	EAE bool
+
+	// Full set of processor IOTs (SKON, SRQ, GTF, RTF, SGT, CAF).
+	// Earlier models only decode ION and IOF.
+	CAF bool
+
+	// Memory extension registers (IF, DF, IB, SF) are on chip, so the field
+	// IOTs work whatever the memory size. Other models need more than 4K of
+	// memory installed, which brings a KM8-E with it.
+	Fields bool
+
+	// HD-6120 stack instructions and control panel memory
+	HD6120 bool
+
+	// Memory cycle time. Instructions take a whole number of cycles.
+	Cycle time.Duration
+
+	// Extra cycles taken by an IOT instruction
+	IOTCycles int
+}
+
+// Supported processor models, selectable with -model
+var CPUModels = map[string]CPUModel{
+	"pdp8": {
+		Name:        "pdp8",
+		Description: "PDP-8",
+		RotateUndef: ROT_UNDEF_OR,
+		EAE:         true,
+		Cycle:       1500 * time.Nanosecond,
+		IOTCycles:   2,
+	},
+	"8i": {
+		Name:        "8i",
+		Description: "PDP-8/I",
+		IACRotate:   true,
+		RotateUndef: ROT_UNDEF_OR,
+		EAE:         true,
+		Cycle:       1500 * time.Nanosecond,
+		IOTCycles:   2,
+	},
+	"8l": {
+		Name:        "8l",
+		Description: "PDP-8/L",
+		IACRotate:   true,
+		RotateUndef: ROT_UNDEF_OR,
+		Cycle:       1600 * time.Nanosecond,
+		IOTCycles:   2,
+	},
+	"8e": {
+		Name:        "8e",
+		Description: "PDP-8/E",
+		IACRotate:   true,
+		BSW:         true,
+		RotateUndef: ROT_UNDEF_8E,
+		MQ:          true,
+		EAE:         true,
+		CAF:         true,
+		Cycle:       1200 * time.Nanosecond,
+		IOTCycles:   1,
+	},
+	"6100": {
+		Name:        "6100",
+		Description: "Intersil IM6100",
+		IACRotate:   true,
+		BSW:         true,
+		RotateUndef: ROT_UNDEF_8E,
+		MQ:          true,
+		CAF:         true,
+		Cycle:       2500 * time.Nanosecond,
+		IOTCycles:   1,
+	},
+	"6120": {
+		Name:        "6120",
+		Description: "Harris HD-6120",
+		IACRotate:   true,
+		BSW:         true,
+		RotateUndef: ROT_UNDEF_6120,
+		MQ:          true,
+		CAF:         true,
+		Fields:      true,
+		HD6120:      true,
+		Cycle:       1000 * time.Nanosecond,
+		IOTCycles:   1,
+	},
+}
+
+// Looks up a model by name, returning an error listing the valid names.
+func LookupCPUModel(name string) (CPUModel, error) {
+	model, ok := CPUModels[strings.ToLower(name)]
+	if !ok {
+		var names []string
+		for n := range CPUModels {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return model, fmt.Errorf("unknown model %q (valid models: %s)", name, strings.Join(names, ", "))
+	}
+	return model, nil
+}
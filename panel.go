@@ -0,0 +1,122 @@
+package main
+
+import "time"
+
+// A PanelKey is a momentary key (or switch change) on the front panel,
+// delivered to the CPU between instructions by FrontPanel.ReadKey.
+type PanelKey int
+
+// Front panel keys, modelled on the PDP-8/E console
+const (
+	KEY_NONE  PanelKey = iota
+	KEY_CONT           // Continue running (one instruction/cycle when stepping)
+	KEY_HALT           // Halt at the end of the current instruction
+	KEY_INST           // Single instruction: execute one instruction and halt
+	KEY_STEP           // Single step: execute one major cycle and halt
+	KEY_LOAD           // Load address: PC and CPMA from SR, fields from switches
+	KEY_DEP            // Deposit SR at CPMA, then increment CPMA
+	KEY_EXAM           // Examine the word at CPMA, then increment CPMA
+	KEY_CLEAR          // Clear AC, L, MQ, the interrupt system and device flags
+)
+
+// Handles a key pressed on the front panel. Keys that change the machine
+// state are only honoured while halted at the end of an instruction, as on
+// the real console.
+func (mk *MK12) operate(key PanelKey) {
+	switch key {
+	case KEY_HALT:
+		mk.STATE.HALT = true
+
+	case KEY_CONT:
+		mk.STATE.SSTEP = false
+		mk.STATE.CSTEP = false
+		mk.STATE.HALT = false
+
+	case KEY_INST:
+		mk.STATE.SSTEP = true
+		mk.STATE.CSTEP = false
+		mk.STATE.HALT = false
+
+	case KEY_STEP:
+		mk.STATE.SSTEP = true
+		mk.STATE.CSTEP = true
+		mk.STATE.HALT = false
+
+	case KEY_LOAD:
+		if !mk.STATE.HALT || mk.fetched {
+			return
+		}
+		ifs, dfs := mk.fp.ReadFieldSwitches()
+		mk.IF = ifs & mk.fieldMask
+		mk.IB = mk.IF
+		mk.DF = dfs & mk.fieldMask
+		mk.PC = mk.SR
+		mk.MA = mk.SR
+
+	case KEY_DEP:
+		if !mk.STATE.HALT || mk.fetched {
+			return
+		}
+		mk.MB = mk.SR
+		mk.write(mk.IF<<12|mk.MA, mk.MB)
+		mk.MA = (mk.MA + 1) % 4096
+		mk.PC = mk.MA
+
+	case KEY_EXAM:
+		if !mk.STATE.HALT || mk.fetched {
+			return
+		}
+		mk.MB = mk.MEM[mk.IF<<12|mk.MA]
+		mk.MA = (mk.MA + 1) % 4096
+		mk.PC = mk.MA
+
+	case KEY_CLEAR:
+		if !mk.STATE.HALT || mk.fetched {
+			return
+		}
+		mk.clearAllFlags()
+		mk.MQ = 0
+	}
+}
+
+// Blank debug command a front panel queues to get an Update while halted,
+// when it needs a copy of memory it doesn't have
+const refreshCommand = " "
+
+// This function handles the HALT state, listening for front panel keys
+func (mk *MK12) halt() {
+	// If EXIT flag is set, we exit upon a halt
+	if mk.STATE.EXIT {
+		return
+	}
+
+	// Show that we've stopped
+	mk.fp.Update(*mk)
+
+	for mk.STATE.HALT {
+		if mk.pollCommand() {
+			mk.fp.Update(*mk)
+		}
+		key := mk.fp.ReadKey()
+		if key == KEY_NONE {
+			// Sleep for a bit - this solves the problem of high cpu usage
+			time.Sleep(time.Millisecond * 1)
+			continue
+		}
+		mk.SR = mk.fp.ReadSwitches()
+		mk.operate(key)
+		mk.fp.Update(*mk)
+	}
+}
+
+// Halts between the fetch and execute cycles when single stepping cycles
+func (mk *MK12) cycleStep() {
+	if !mk.STATE.CSTEP || mk.STATE.EXIT {
+		return
+	}
+	mk.fetched = true
+	mk.STATE.HALT = true
+	mk.fp.Update(*mk)
+	mk.halt()
+	mk.fetched = false
+}
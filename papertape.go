@@ -0,0 +1,443 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"time"
+)
+
+// PC8-E function codes, the reader at PT_READER and the punch at PT_PUNCH
+const (
+	RPE = 0o0 // Set the reader and punch interrupt enable
+	RSF = 0o1 // Skip on reader flag
+	RRB = 0o2 // OR the reader buffer into AC, clear the reader flag
+	RFC = 0o4 // Clear the reader flag and fetch the next character
+
+	PCE = 0o0 // Clear the reader and punch interrupt enable
+	PSF = 0o1 // Skip on punch flag
+	PCF = 0o2 // Clear the punch flag
+	PPC = 0o4 // Load the punch buffer from AC and punch it
+)
+
+// The reader reads 300 characters a second and the punch punches 50
+const (
+	PT_readTime  = time.Second / 300
+	PT_punchTime = time.Second / 50
+)
+
+///////////////////////////////////
+// Paper Tape Reader/Punch Device (PC8-E)
+//
+
+// The reader fetches a character when told to by RFC and sets its flag once
+// it is in the reader buffer. At the end of the tape nothing more is read and
+// the flag stays clear, as with a real reader run out of tape. Punched
+// characters are written to a file, or thrown away if there isn't one.
+type PaperTapeDevice struct {
+	Device
+
+	tape     *bufio.Reader
+	tapeFile *os.File
+	tapeName string
+	tapePos  int
+	fetching bool
+
+	punch    *os.File
+	punching bool
+
+	// Reader buffer and flag
+	RB uint16
+	RF bool
+
+	// Punch buffer and flag
+	PB uint16
+	PF bool
+
+	// Interrupt enable, set on power up and by CAF
+	IE bool
+
+	mk  *MK12
+	ac  uint16
+	dev uint16
+}
+
+func NewPaperTape() *PaperTapeDevice {
+	return &PaperTapeDevice{IE: true}
+}
+
+// Puts a tape in the reader, at its start
+func (pt *PaperTapeDevice) Load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	pt.Unload()
+	pt.tapeFile = f
+	pt.tape = bufio.NewReader(f)
+	pt.tapeName = path
+	pt.tapePos = 0
+	return nil
+}
+
+// Takes the tape out of the reader
+func (pt *PaperTapeDevice) Unload() {
+	if pt.tapeFile != nil {
+		pt.tapeFile.Close()
+	}
+	pt.tapeFile, pt.tape, pt.tapeName = nil, nil, ""
+}
+
+// Punches onto a new tape in the file at path
+func (pt *PaperTapeDevice) Punch(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if pt.punch != nil {
+		pt.punch.Close()
+	}
+	pt.punch = f
+	return nil
+}
+
+// Closes the tape files before the simulator exits
+func (pt *PaperTapeDevice) Close() {
+	pt.Unload()
+	if pt.punch != nil {
+		pt.punch.Close()
+		pt.punch = nil
+	}
+}
+
+func (pt *PaperTapeDevice) Select(addr uint16, mk *MK12) bool {
+	if addr != PT_READER && addr != PT_PUNCH {
+		return false
+	}
+	pt.dev = addr
+	pt.mk = mk
+	pt.ac = mk.AC
+	return true
+}
+
+func (pt *PaperTapeDevice) Get() uint16 {
+	return pt.RB
+}
+
+func (pt *PaperTapeDevice) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	if pt.dev == PT_READER {
+		if fn == RPE {
+			pt.IE = true
+		}
+		skip = fn&RSF != 0 && pt.RF
+		if fn&RRB != 0 {
+			or = true
+			pt.RF = false
+		}
+		if fn&RFC != 0 {
+			pt.RF = false
+			pt.fetch()
+		}
+		return
+	}
+
+	if fn == PCE {
+		pt.IE = false
+	}
+	skip = fn&PSF != 0 && pt.PF
+	if fn&PCF != 0 {
+		pt.PF = false
+	}
+	if fn&PPC != 0 {
+		pt.PB = pt.ac & 0o377
+		pt.punchChar()
+	}
+	return
+}
+
+// Starts reading the next character from the tape
+func (pt *PaperTapeDevice) fetch() {
+	if pt.fetching || pt.tape == nil {
+		return
+	}
+	pt.fetching = true
+	pt.mk.schedule(PT_readTime, func() {
+		pt.fetching = false
+		if pt.tape == nil {
+			return
+		}
+		c, err := pt.tape.ReadByte()
+		if err != nil {
+			return
+		}
+		pt.tapePos++
+		pt.RB = uint16(c)
+		pt.RF = true
+	})
+}
+
+// Starts punching the punch buffer
+func (pt *PaperTapeDevice) punchChar() {
+	if pt.punching {
+		return
+	}
+	pt.punching = true
+	pt.mk.schedule(PT_punchTime, func() {
+		pt.punching = false
+		if pt.punch != nil {
+			pt.punch.Write([]byte{byte(pt.PB)})
+		}
+		pt.PF = true
+	})
+}
+
+func (pt *PaperTapeDevice) InterruptRequest() bool {
+	return pt.IE && (pt.RF || pt.PF)
+}
+
+// CAF clears both flags and sets the interrupt enable
+func (pt *PaperTapeDevice) ClearFlags() {
+	pt.RF = false
+	pt.PF = false
+	pt.IE = true
+}
+
+// Returns the paper tape reader and punch
+func (mk *MK12) paperTape() *PaperTapeDevice {
+	for _, dev := range mk.IOT {
+		if pt, ok := dev.(*PaperTapeDevice); ok {
+			return pt
+		}
+	}
+	return nil
+}
+
+func init() {
+	debugCommands["tape"] = DebugCommand{
+		Usage: "[path]",
+		Help:  "Load a paper tape into the reader, or show the tape loaded",
+		Run:   cmdTape,
+		Files: true,
+	}
+	loaders["rim"] = Loader{
+		Help:   "RIM loader for the PC8-E reader",
+		Origin: 0o7756,
+		Start:  0o7756,
+		Words:  rimHighSpeed,
+	}
+	loaders["lsrim"] = Loader{
+		Help:   "RIM loader for the Teletype reader",
+		Origin: 0o7756,
+		Start:  0o7756,
+		Words:  rimLowSpeed,
+	}
+	loaders["bin"] = Loader{
+		Help:   "BIN loader for the PC8-E reader, halts with AC 0 if the checksum is right",
+		Origin: 0o7600,
+		Start:  0o7600,
+		Words:  binLoader,
+	}
+	loaders["lsbin"] = Loader{
+		Help:   "BIN loader for the Teletype reader, halts with AC 0 if the checksum is right",
+		Origin: 0o7600,
+		Start:  0o7600,
+		Words:  teletypeReader(binLoader),
+	}
+}
+
+func cmdTape(mk *MK12, args []string) (string, error) {
+	pt := mk.paperTape()
+	if pt == nil {
+		return "", fmt.Errorf("no paper tape reader")
+	}
+	switch len(args) {
+	case 0:
+		if pt.tape == nil {
+			return "no tape in the reader", nil
+		}
+		return fmt.Sprintf("%s, %d characters read", pt.tapeName, pt.tapePos), nil
+	case 1:
+		if err := pt.Load(args[0]); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s loaded", args[0]), nil
+	}
+	return "", fmt.Errorf("usage: tape [path]")
+}
+
+// The loaders are written for the PC8-E reader. The Teletype reader versions
+// use the keyboard IOTs in place of RFC, RSF and RRB RFC.
+func teletypeReader(words []uint16) []uint16 {
+	out := make([]uint16, len(words))
+	for i, w := range words {
+		switch w {
+		case 0o6014: // RFC
+			w = 0o6032 // KCC
+		case 0o6011: // RSF
+			w = 0o6031 // KSF
+		case 0o6016: // RRB RFC
+			w = 0o6036 // KRB
+		}
+		out[i] = w
+	}
+	return out
+}
+
+// The RIM loaders from the PDP-8/E handbook. Each word of a RIM tape is
+// preceded by its address, the address marked by channel 7, and the loader
+// never halts: once the tape has gone through stop the machine and start the
+// program.
+var rimHighSpeed = []uint16{
+	0o6014, // 7756 BEG,    RFC
+	0o6011, // 7757         RSF
+	0o5357, // 7760         JMP .-1
+	0o6016, // 7761         RRB RFC
+	0o7106, // 7762         CLL RTL
+	0o7006, // 7763         RTL
+	0o7510, // 7764         SPA             / Leader?
+	0o5374, // 7765         JMP 7774
+	0o7006, // 7766         RTL             / Channel 7 into the link
+	0o6011, // 7767         RSF
+	0o5367, // 7770         JMP .-1
+	0o6016, // 7771         RRB RFC
+	0o7420, // 7772         SNL
+	0o3776, // 7773         DCA I TEMP      / Data
+	0o3376, // 7774         DCA TEMP        / Address
+	0o5357, // 7775         JMP BEG+1
+	0o0000, // 7776 TEMP,   0
+}
+
+var rimLowSpeed = []uint16{
+	0o6032, // 7756 BEG,    KCC
+	0o6031, // 7757         KSF
+	0o5357, // 7760         JMP .-1
+	0o6036, // 7761         KRB
+	0o7106, // 7762         CLL RTL
+	0o7006, // 7763         RTL
+	0o7510, // 7764         SPA             / Leader?
+	0o5357, // 7765         JMP BEG+1
+	0o7006, // 7766         RTL             / Channel 7 into the link
+	0o6031, // 7767         KSF
+	0o5367, // 7770         JMP .-1
+	0o6034, // 7771         KRS
+	0o7420, // 7772         SNL
+	0o3776, // 7773         DCA I TEMP      / Data
+	0o3376, // 7774         DCA TEMP        / Address
+	0o5356, // 7775         JMP BEG
+	0o0000, // 7776 TEMP,   0
+}
+
+// A BIN loader. Frames with channel 7 set are origins, the others pairs of
+// six bit halves of a word. Field settings (channels 8 and 7) select the
+// field words go to, rubouts bracket comments and the last word before the
+// trailer is the checksum, the sum of all the frames before it. Leaves the
+// RIM loader at 7756 alone.
+var binLoader = []uint16{
+	0o6014, // 7600 BEGIN,  RFC            / Start the reader
+	0o7300, // 7601         CLA CLL
+	0o3344, // 7602         DCA SUM
+	0o3345, // 7603         DCA PEND
+	0o1343, // 7604         TAD CDF0       / Field 0 until the tape sets one
+	0o3322, // 7605         DCA FIELD
+	0o4330, // 7606 LEAD,   JMS GETC       / Find the leader
+	0o1346, // 7607         TAD CHAR
+	0o1336, // 7610         TAD M200
+	0o7640, // 7611         SZA CLA
+	0o5206, // 7612         JMP LEAD
+	0o4330, // 7613 SKIP,   JMS GETC       / and go past it
+	0o1346, // 7614         TAD CHAR
+	0o1336, // 7615         TAD M200
+	0o7650, // 7616         SNA CLA
+	0o5213, // 7617         JMP SKIP
+	0o1346, // 7620 FRAME,  TAD CHAR       / Rubout starts a comment
+	0o1337, // 7621         TAD M377
+	0o7650, // 7622         SNA CLA
+	0o5300, // 7623         JMP RUBOUT
+	0o1346, // 7624         TAD CHAR       / Leader, trailer or field setting
+	0o1336, // 7625         TAD M200
+	0o7700, // 7626         SMA CLA
+	0o5266, // 7627         JMP HIGH
+	0o4314, // 7630         JMS STORE      / Store the last word, it wasn't the checksum
+	0o1346, // 7631         TAD CHAR
+	0o3350, // 7632         DCA WSUM
+	0o1346, // 7633         TAD CHAR
+	0o7106, // 7634         CLL RTL        / Top six bits
+	0o7006, // 7635         RTL
+	0o7006, // 7636         RTL            / Link set for an origin
+	0o3347, // 7637         DCA WORD
+	0o7004, // 7640         RAL
+	0o3351, // 7641         DCA ORIGIN
+	0o4330, // 7642         JMS GETC       / Bottom six bits
+	0o1346, // 7643         TAD CHAR
+	0o0341, // 7644         AND K77
+	0o1347, // 7645         TAD WORD
+	0o3347, // 7646         DCA WORD
+	0o1346, // 7647         TAD CHAR
+	0o1350, // 7650         TAD WSUM
+	0o3350, // 7651         DCA WSUM
+	0o1350, // 7652         TAD WSUM       / Add both frames to the checksum
+	0o1344, // 7653         TAD SUM
+	0o3344, // 7654         DCA SUM
+	0o1351, // 7655         TAD ORIGIN
+	0o7650, // 7656         SNA CLA
+	0o5263, // 7657         JMP DATA
+	0o1347, // 7660         TAD WORD
+	0o3352, // 7661         DCA ADDR
+	0o5264, // 7662         JMP NEXT
+	0o2345, // 7663 DATA,   ISZ PEND       / Hold on to it in case it is the checksum
+	0o4330, // 7664 NEXT,   JMS GETC
+	0o5220, // 7665         JMP FRAME
+	0o1346, // 7666 HIGH,   TAD CHAR       / Field setting or trailer?
+	0o0340, // 7667         AND K100
+	0o7650, // 7670         SNA CLA
+	0o5306, // 7671         JMP END
+	0o4314, // 7672         JMS STORE
+	0o1346, // 7673         TAD CHAR       / CDF to the field
+	0o0342, // 7674         AND K70
+	0o1343, // 7675         TAD CDF0
+	0o3322, // 7676         DCA FIELD
+	0o5264, // 7677         JMP NEXT
+	0o4330, // 7700 RUBOUT, JMS GETC       / Skip to the next rubout
+	0o1346, // 7701         TAD CHAR
+	0o1337, // 7702         TAD M377
+	0o7640, // 7703         SZA CLA
+	0o5300, // 7704         JMP RUBOUT
+	0o5264, // 7705         JMP NEXT
+	0o1347, // 7706 END,    TAD WORD       / The last word is the checksum
+	0o1350, // 7707         TAD WSUM
+	0o7041, // 7710         CIA
+	0o1344, // 7711         TAD SUM
+	0o7402, // 7712         HLT            / AC is zero if it matched
+	0o5200, // 7713         JMP BEGIN      / CONT loads another tape
+	0o0000, // 7714 STORE,  0              / Store the held word
+	0o1345, // 7715         TAD PEND
+	0o7650, // 7716         SNA CLA
+	0o5714, // 7717         JMP I STORE
+	0o3345, // 7720         DCA PEND
+	0o1347, // 7721         TAD WORD
+	0o6201, // 7722 FIELD,  CDF 0
+	0o3752, // 7723         DCA I ADDR
+	0o6201, // 7724         CDF 0
+	0o2352, // 7725         ISZ ADDR
+	0o7000, // 7726         NOP
+	0o5714, // 7727         JMP I STORE
+	0o0000, // 7730 GETC,   0              / Read a character
+	0o6011, // 7731         RSF
+	0o5331, // 7732         JMP .-1
+	0o6016, // 7733         RRB RFC
+	0o3346, // 7734         DCA CHAR
+	0o5730, // 7735         JMP I GETC
+	0o7600, // 7736 M200,   7600
+	0o7401, // 7737 M377,   7401
+	0o0100, // 7740 K100,   0100
+	0o0077, // 7741 K77,    0077
+	0o0070, // 7742 K70,    0070
+	0o6201, // 7743 CDF0,   6201
+	0o0000, // 7744 SUM,    0
+	0o0000, // 7745 PEND,   0
+	0o0000, // 7746 CHAR,   0
+	0o0000, // 7747 WORD,   0
+	0o0000, // 7750 WSUM,   0
+	0o0000, // 7751 ORIGIN, 0
+	0o0000, // 7752 ADDR,   0
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Incremental plotter device codes
+const (
+	PL_DEV_PEN   = 0o50 // Flag and pen up
+	PL_DEV_RIGHT = 0o51 // Pen right, drum up and down
+	PL_DEV_LEFT  = 0o52 // Pen left, drum up and pen down
+)
+
+// Plotter function codes, by device code. Functions for the same device can
+// be combined, PLPR PLDU steps diagonally.
+const (
+	PLSF = 0o1 // Skip on plotter flag (50)
+	PLCF = 0o2 // Clear the plotter flag (50)
+	PLPU = 0o4 // Pen up (50)
+
+	PLPR = 0o1 // Pen right (51)
+	PLDU = 0o2 // Drum up (51)
+	PLDD = 0o4 // Drum down (51)
+
+	PLPL = 0o1 // Pen left (52)
+	PLUD = 0o2 // Drum up (52)
+	PLPD = 0o4 // Pen down (52)
+)
+
+// A step takes 1/300 of a second and lifting or lowering the pen 1/10
+const (
+	PL_stepTime = time.Second / 300
+	PL_penTime  = time.Second / 10
+)
+
+// Step size and paper size in inches, set with -plot-step and -plot-paper
+var (
+	plotterStep   = 0.01
+	plotterWidth  = 11.0
+	plotterHeight = 17.0
+)
+
+// Plotter is a Calcomp style incremental plotter. The pen moves left and
+// right across the drum and the drum moves the paper up and down under it,
+// a step at a time in any of eight directions, and the flag is set once a
+// step or a pen movement is done. The pen starts up at the bottom left corner
+// of the paper and stops at its edges. The lines drawn are kept and written
+// to the attached file as an SVG drawing when it is detached, which happens
+// when the simulator exits.
+type Plotter struct {
+	Device
+
+	Flag bool
+
+	// Pen position in steps, from the bottom left corner
+	X, Y int
+	Down bool
+
+	// Lines drawn so far, each a run of positions with the pen down
+	lines [][][2]int
+
+	img *diskImage
+
+	busy bool
+	mk   *MK12
+	dev  uint16
+}
+
+func NewPlotter() Storage {
+	return &Plotter{}
+}
+
+func init() {
+	storageControllers["pl"] = NewPlotter
+}
+
+func (pl *Plotter) DriveName() string {
+	return "pl"
+}
+
+func (pl *Plotter) Drives() int {
+	return 1
+}
+
+// Attaching a file puts in a fresh sheet of paper
+func (pl *Plotter) Attach(drive int, path string, readOnly bool) error {
+	if readOnly {
+		return fmt.Errorf("a plot can't be written to a read only file")
+	}
+	img, err := openImage(path, false)
+	if err != nil {
+		return err
+	}
+	pl.img = img
+	pl.lines = nil
+	pl.X, pl.Y, pl.Down = 0, 0, false
+	return nil
+}
+
+// Detaching writes out the drawing
+func (pl *Plotter) Detach(drive int) error {
+	if pl.img == nil {
+		return fmt.Errorf("nothing is attached to pl%d", drive)
+	}
+	err := pl.img.f.Truncate(0)
+	if err == nil {
+		err = pl.img.WriteBytes(0, pl.svg())
+	}
+	if cerr := pl.img.Close(); err == nil {
+		err = cerr
+	}
+	pl.img = nil
+	return err
+}
+
+func (pl *Plotter) Image(drive int) *diskImage {
+	return pl.img
+}
+
+func (pl *Plotter) Select(addr uint16, mk *MK12) bool {
+	if addr < PL_DEV_PEN || addr > PL_DEV_LEFT {
+		return false
+	}
+	pl.dev = addr
+	pl.mk = mk
+	return true
+}
+
+func (pl *Plotter) Get() uint16 {
+	return 0
+}
+
+func (pl *Plotter) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	dx, dy := 0, 0
+	switch pl.dev {
+	case PL_DEV_PEN:
+		skip = fn&PLSF != 0 && pl.Flag
+		if fn&PLCF != 0 {
+			pl.Flag = false
+		}
+		if fn&PLPU != 0 {
+			pl.pen(false)
+		}
+		return
+	case PL_DEV_RIGHT:
+		if fn&PLPR != 0 {
+			dx++
+		}
+		if fn&PLDU != 0 {
+			dy++
+		}
+		if fn&PLDD != 0 {
+			dy--
+		}
+	case PL_DEV_LEFT:
+		if fn&PLPL != 0 {
+			dx--
+		}
+		if fn&PLUD != 0 {
+			dy++
+		}
+		if fn&PLPD != 0 {
+			pl.pen(true)
+		}
+	}
+	if dx != 0 || dy != 0 {
+		pl.step(dx, dy)
+	}
+	return
+}
+
+// Lifts or lowers the pen, lowering it marks a dot
+func (pl *Plotter) pen(down bool) {
+	if down && !pl.Down {
+		pl.lines = append(pl.lines, [][2]int{{pl.X, pl.Y}})
+	}
+	pl.Down = down
+	pl.start(PL_penTime)
+}
+
+// Moves the pen a step, stopping at the edges of the paper
+func (pl *Plotter) step(dx, dy int) {
+	oldX, oldY := pl.X, pl.Y
+	x, y := pl.X+dx, pl.Y+dy
+	if x >= 0 && x <= pl.steps(plotterWidth) {
+		pl.X = x
+	}
+	if y >= 0 && y <= pl.steps(plotterHeight) {
+		pl.Y = y
+	}
+	if pl.Down && (pl.X != oldX || pl.Y != oldY) {
+		line := pl.lines[len(pl.lines)-1]
+		p := [2]int{pl.X, pl.Y}
+		// Moves in the same direction extend the last segment. A step cut
+		// short at an edge moves in another direction and starts a new one.
+		if n := len(line); n >= 2 && sign(line[n-1][0]-line[n-2][0]) == pl.X-oldX && sign(line[n-1][1]-line[n-2][1]) == pl.Y-oldY {
+			line[n-1] = p
+		} else {
+			line = append(line, p)
+		}
+		pl.lines[len(pl.lines)-1] = line
+	}
+	pl.start(PL_stepTime)
+}
+
+// Returns -1, 0 or 1 for the sign of n
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// Sets the flag once the plotter has done what it was told
+func (pl *Plotter) start(delay time.Duration) {
+	if pl.busy {
+		return
+	}
+	pl.busy = true
+	pl.mk.schedule(delay, func() {
+		pl.busy = false
+		pl.Flag = true
+	})
+}
+
+// Returns the number of steps in a length in inches
+func (pl *Plotter) steps(inches float64) int {
+	return int(inches/plotterStep + 0.5)
+}
+
+// The plotter interrupts whenever its flag is set
+func (pl *Plotter) InterruptRequest() bool {
+	return pl.Flag
+}
+
+// CAF clears the flag
+func (pl *Plotter) ClearFlags() {
+	pl.Flag = false
+}
+
+// Returns the drawing as SVG, in steps with the paper's size in inches
+func (pl *Plotter) svg() []byte {
+	w, h := pl.steps(plotterWidth), pl.steps(plotterHeight)
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%.5gin\" height=\"%.5gin\" viewBox=\"0 0 %d %d\">\n",
+		plotterWidth, plotterHeight, w, h)
+	fmt.Fprintf(&b, "<rect width=\"%d\" height=\"%d\" fill=\"white\"/>\n", w, h)
+	fmt.Fprintf(&b, "<g fill=\"none\" stroke=\"black\" stroke-width=\"%g\" stroke-linecap=\"round\" stroke-linejoin=\"round\">\n",
+		0.012/plotterStep)
+	for _, line := range pl.lines {
+		if len(line) == 1 {
+			line = append(line, line[0])
+		}
+		points := make([]string, len(line))
+		for i, p := range line {
+			points[i] = fmt.Sprintf("%d,%d", p[0], h-p[1])
+		}
+		fmt.Fprintf(&b, "<polyline points=\"%s\"/>\n", strings.Join(points, " "))
+	}
+	b.WriteString("</g>\n</svg>\n")
+	return b.Bytes()
+}
+
+// Parses a length such as 0.01in or 0.25mm, inches if there's no unit
+func parseLength(s string) (float64, error) {
+	n, scale := strings.TrimSuffix(s, "in"), 1.0
+	if strings.HasSuffix(s, "mm") {
+		n, scale = strings.TrimSuffix(s, "mm"), 1/25.4
+	}
+	v, err := strconv.ParseFloat(n, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("bad length %q", s)
+	}
+	return v * scale, nil
+}
+
+// Sets the plotter's step size and paper size, the paper given as WxH with
+// the unit after H, 11x17in for example
+func setPlotter(step string, paper string) error {
+	s, err := parseLength(step)
+	if err != nil {
+		return err
+	}
+	w, h, ok := strings.Cut(paper, "x")
+	if !ok {
+		return fmt.Errorf("paper size %q is not WxH", paper)
+	}
+	unit := strings.TrimLeft(h, "0123456789.")
+	width, err := parseLength(w + unit)
+	if err != nil {
+		return err
+	}
+	height, err := parseLength(h)
+	if err != nil {
+		return err
+	}
+	plotterStep, plotterWidth, plotterHeight = s, width, height
+	return nil
+}
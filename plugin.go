@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Version of the plugin protocol sent in HELLO
+const PLUGIN_VERSION = 1
+
+// How often messages a plugin sends on its own are picked up, in simulated
+// time, how long it has to answer HELLO, and how long it can go quiet while
+// the simulator waits for DONE
+const (
+	PLUGIN_pollTime  = 100 * time.Microsecond
+	PLUGIN_helloTime = 5 * time.Second
+	PLUGIN_doneTime  = 5 * time.Second
+)
+
+// PluginDevice is a device simulated by another process, talked to over a
+// unix socket it listens on. The protocol is lines of words, numbers in octal
+// except for times which are decimal nanoseconds of simulated time.
+//
+// The simulator connects and sends HELLO with the protocol version, and the
+// plugin answers with CODES and the device codes it wants. Each IOT to one of
+// them is sent as IOT with the device code, the function code (the IOP1, IOP2
+// and IOP4 pulses as bits 1, 2 and 4), AC and the time, and CAF is sent as
+// CAF. The plugin answers IOT with DONE and the skip, clear AC and OR flags as
+// 0 or 1 and the data to OR into AC, and CAF with DONE.
+//
+// While the simulator waits for DONE the plugin can send:
+//
+//	IRQ 1|0                  request an interrupt or stop requesting one
+//	IN addr data             single cycle data break of data into addr
+//	OUT addr                 single cycle data break out of addr, answered
+//	                         with DATA addr data
+//	WC wc field inc          word count and current address cycles of a
+//	                         three cycle break at wc in field 0, inc 0 to not
+//	                         increment the current address, answered with
+//	                         CA addr overflow for the data cycle's IN or OUT
+//	AFTER ns                 send TIME with the time after ns nanoseconds,
+//	                         which the plugin answers with DONE in turn
+//
+// These are also taken between instructions if the plugin sends them on its
+// own, which is only as deterministic as the plugin's timing. The simulator
+// answers anything it doesn't understand with ERROR and sends BYE before
+// closing the socket. A plugin that sends nothing for 5 seconds while the
+// simulator waits for DONE is disconnected.
+type PluginDevice struct {
+	Device
+
+	Path  string
+	Codes []uint16
+	IRQ   bool
+
+	conn   net.Conn
+	w      *bufio.Writer
+	lines  chan string // From the plugin, closed when it goes away
+	closed bool
+
+	mk   *MK12
+	code uint16
+	ac   uint16
+	data uint16 // ORed into AC
+}
+
+// Connects to a plugin listening on the unix socket at path
+func NewPluginDevice(mk *MK12, path string) (*PluginDevice, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PluginDevice{Path: path, conn: conn, w: bufio.NewWriter(conn), lines: make(chan string, 64), mk: mk}
+	go func() {
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			p.lines <- s.Text()
+		}
+		close(p.lines)
+	}()
+
+	p.send("HELLO %d", PLUGIN_VERSION)
+	var fields []string
+	select {
+	case line := <-p.lines:
+		fields = strings.Fields(line)
+	case <-time.After(PLUGIN_helloTime):
+	}
+	if len(fields) < 2 || fields[0] != "CODES" {
+		conn.Close()
+		return nil, fmt.Errorf("plugin %s didn't answer HELLO with CODES", path)
+	}
+	for _, f := range fields[1:] {
+		n, err := strconv.ParseUint(f, 8, 6)
+		if err != nil || n < 0o01 || n > 0o76 || n == CPU_IOT || (n >= MEM_IOT_begin && n <= MEM_IOT_end) {
+			conn.Close()
+			return nil, fmt.Errorf("plugin %s device code %q is not octal 01-76 or is used by the processor", path, f)
+		}
+		p.Codes = append(p.Codes, uint16(n))
+	}
+	mk.schedule(0, p.poll)
+	return p, nil
+}
+
+func (p *PluginDevice) send(format string, a ...interface{}) {
+	if p.closed {
+		return
+	}
+	fmt.Fprintf(p.w, format+"\n", a...)
+	if err := p.w.Flush(); err != nil {
+		p.disconnected()
+	}
+}
+
+// Marks the plugin gone, along with any interrupt it was requesting
+func (p *PluginDevice) disconnected() {
+	p.closed = true
+	p.IRQ = false
+}
+
+// Handles what the plugin sends until DONE, returning its fields, nil if the
+// plugin has gone away or stopped answering
+func (p *PluginDevice) wait() []string {
+	for !p.closed {
+		var line string
+		var ok bool
+		select {
+		case line, ok = <-p.lines:
+		case <-time.After(PLUGIN_doneTime):
+			p.mk.fp.Print(fmt.Sprintf("ERROR: plugin %s didn't answer for %v, disconnecting it", p.Path, PLUGIN_doneTime))
+			p.Close()
+			return nil
+		}
+		if !ok {
+			p.disconnected()
+			break
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "DONE" {
+			return fields
+		}
+		p.handle(fields)
+	}
+	return nil
+}
+
+// Picks up what the plugin has sent on its own
+func (p *PluginDevice) poll() {
+	for !p.closed {
+		select {
+		case line, ok := <-p.lines:
+			if !ok {
+				p.disconnected()
+				return
+			}
+			p.handle(strings.Fields(line))
+		default:
+			p.mk.schedule(PLUGIN_pollTime, p.poll)
+			return
+		}
+	}
+}
+
+// Carries out a request from the plugin
+func (p *PluginDevice) handle(fields []string) {
+	if len(fields) == 0 {
+		return
+	}
+	args, err := pluginNumbers(fields[1:])
+	switch {
+	case fields[0] == "AFTER" && len(fields) == 2:
+		ns, err := strconv.ParseInt(fields[1], 10, 64)
+		if err == nil && ns >= 0 {
+			p.mk.schedule(time.Duration(ns), func() {
+				p.send("TIME %d", p.mk.HW.TIME)
+				p.wait()
+			})
+			return
+		}
+	case err != nil:
+	case fields[0] == "IRQ" && len(args) == 1:
+		p.IRQ = args[0] != 0
+		return
+	case fields[0] == "IN" && len(args) == 2:
+		p.mk.breakIn(uint16(args[0]), uint16(args[1])&0o7777)
+		return
+	case fields[0] == "OUT" && len(args) == 1:
+		p.send("DATA %05o %04o", args[0]&0o77777, p.mk.breakOut(uint16(args[0])))
+		return
+	case fields[0] == "WC" && len(args) == 3:
+		addr, overflow := p.mk.threeCycle(uint16(args[0])&0o7777, uint16(args[1])&0o7, args[2] != 0)
+		p.send("CA %05o %d", addr, pluginFlag(overflow))
+		return
+	}
+	p.send("ERROR %s", strings.Join(fields, " "))
+}
+
+// Parses octal numbers
+func pluginNumbers(fields []string) ([]uint64, error) {
+	n := make([]uint64, len(fields))
+	for i, f := range fields {
+		v, err := strconv.ParseUint(f, 8, 15)
+		if err != nil {
+			return nil, err
+		}
+		n[i] = v
+	}
+	return n, nil
+}
+
+func pluginFlag(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (p *PluginDevice) Select(addr uint16, mk *MK12) bool {
+	for _, code := range p.Codes {
+		if addr == code {
+			p.code = addr
+			p.ac = mk.AC
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PluginDevice) Get() uint16 {
+	return p.data
+}
+
+func (p *PluginDevice) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	p.send("IOT %02o %o %04o %d", p.code, fn, p.ac, p.mk.HW.TIME)
+	fields := p.wait()
+	if len(fields) != 5 {
+		if fields != nil {
+			p.send("ERROR %s", strings.Join(fields, " "))
+		}
+		return
+	}
+	n, err := pluginNumbers(fields[1:])
+	if err != nil {
+		p.send("ERROR %s", strings.Join(fields, " "))
+		return
+	}
+	p.data = uint16(n[3]) & 0o7777
+	return n[0] != 0, n[1] != 0, n[2] != 0
+}
+
+func (p *PluginDevice) InterruptRequest() bool {
+	return p.IRQ
+}
+
+// CAF is passed on to the plugin to clear its flags
+func (p *PluginDevice) ClearFlags() {
+	p.send("CAF")
+	p.wait()
+}
+
+func (p *PluginDevice) Close() error {
+	p.send("BYE")
+	p.disconnected()
+	return p.conn.Close()
+}
+
+// Connects the plugins given with -device as sock:path. Plugins come before
+// the other devices so they can take over their device codes, which is
+// printed so it isn't done by mistake.
+func (mk *MK12) attachPlugins(specs []string) error {
+	devices := mk.usedDeviceCodes()
+	used := map[uint16]string{}
+	for _, spec := range specs {
+		kind, path, _ := strings.Cut(spec, ":")
+		if kind != "sock" || path == "" {
+			return fmt.Errorf("device %q is not sock:path", spec)
+		}
+		p, err := NewPluginDevice(mk, path)
+		if err != nil {
+			return err
+		}
+		for _, code := range p.Codes {
+			if other, ok := used[code]; ok {
+				p.Close()
+				return fmt.Errorf("plugins %s and %s both want device code %02o", other, path, code)
+			}
+			used[code] = path
+			if name, ok := devices[code]; ok {
+				mk.fp.Print(fmt.Sprintf("Plugin %s takes over device code %02o from %s", path, code, name))
+			}
+		}
+		mk.IOT = append([]Device{p}, mk.IOT...)
+	}
+	return nil
+}
+
+// Says goodbye to the plugins before the simulator exits
+func (mk *MK12) closePlugins() {
+	for _, dev := range mk.IOT {
+		if p, ok := dev.(*PluginDevice); ok {
+			p.Close()
+		}
+	}
+}
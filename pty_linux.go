@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// Characters the printer holds while the pty is backed up before it stops
+// being ready
+const PTY_buffer = 4096
+
+// PtyTerminal is a serial line on a pseudo-terminal. Programs like screen or
+// minicom open the slave side, whose path is printed on startup, just like a
+// real serial port. The slave is put in raw mode so bytes go through as they
+// are.
+type PtyTerminal struct {
+	master *os.File
+	slave  *os.File // Held open so the line stays up between users
+	Path   string
+
+	// Characters typed but not read yet
+	in chan byte
+
+	// Characters printed that didn't fit in the pty yet
+	out []byte
+}
+
+// Creates a new pseudo-terminal
+func NewPtyTerminal() (*PtyTerminal, error) {
+	master, path, err := openPty()
+	if err != nil {
+		return nil, err
+	}
+	slave, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, err
+	}
+	if err := makeRaw(slave); err != nil {
+		master.Close()
+		slave.Close()
+		return nil, err
+	}
+	t := &PtyTerminal{
+		master: master,
+		slave:  slave,
+		Path:   path,
+		in:     make(chan byte, 256),
+	}
+	go t.read()
+	fmt.Fprintf(os.Stderr, "Teletype on pty %s\n", path)
+	return t, nil
+}
+
+// Creates a pty for a serial line
+func openPtyLine() (TeleTypeKeyboard, TeleTypePrinter, error) {
+	t, err := NewPtyTerminal()
+	if err != nil {
+		return nil, nil, err
+	}
+	return t, t, nil
+}
+
+func (t *PtyTerminal) read() {
+	buf := make([]byte, 256)
+	for {
+		n, err := t.master.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, c := range buf[:n] {
+			t.in <- c
+		}
+	}
+}
+
+func (t *PtyTerminal) Buffered() int {
+	return len(t.in)
+}
+
+func (t *PtyTerminal) ReadByte() (byte, error) {
+	select {
+	case c := <-t.in:
+		return c, nil
+	default:
+		return 0, nil
+	}
+}
+
+func (t *PtyTerminal) WriteByte(c byte) error {
+	if len(t.out) < PTY_buffer {
+		t.out = append(t.out, c)
+	}
+	return nil
+}
+
+// Writes as much as the pty will take without waiting for it
+func (t *PtyTerminal) Flush() error {
+	if len(t.out) == 0 {
+		return nil
+	}
+	rc, err := t.master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return rc.Write(func(fd uintptr) bool {
+		n, err := syscall.Write(int(fd), t.out)
+		if err == nil {
+			t.out = t.out[:copy(t.out, t.out[n:])]
+		}
+		return true
+	})
+}
+
+// The printer is not ready while the output is backed up
+func (t *PtyTerminal) Available() int {
+	t.Flush()
+	return PTY_buffer - len(t.out)
+}
+
+// Gives whoever has the slave open a moment to read what was printed before
+// the line hangs up, which throws it away
+func (t *PtyTerminal) Close() error {
+	t.Flush()
+	for i := 0; i < 100; i++ {
+		var n int32
+		if ioctl(t.slave, syscall.TIOCINQ, unsafe.Pointer(&n)) != nil || n == 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.slave.Close()
+	return t.master.Close()
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req, uintptr(arg))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// Opens a new pseudo-terminal, returning the master and the path of the slave
+func openPty() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, unsafe.Pointer(&n)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+		master.Close()
+		return nil, "", err
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// Puts a terminal in raw mode, as cfmakeraw(3) does
+func makeRaw(f *os.File) error {
+	var t syscall.Termios
+	if err := ioctl(f, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	return ioctl(f, syscall.TCSETS, unsafe.Pointer(&t))
+}
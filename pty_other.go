@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+func openPtyLine() (TeleTypeKeyboard, TeleTypePrinter, error) {
+	return nil, nil, errors.New("pty lines are only supported on Linux")
+}
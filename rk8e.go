@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RK8-E disk controller device code
+const RK_DEV = 0o74
+
+// RK8-E function codes
+const (
+	DSKP = 0o1 // Skip on transfer done or error
+	DCLR = 0o2 // Clear, what is cleared is selected by AC bits 10-11
+	DLAG = 0o3 // Load disk address and go
+	DLCA = 0o4 // Load current address
+	DRST = 0o5 // Read status
+	DLDC = 0o6 // Load command register
+	DMAN = 0o7 // Maintenance
+)
+
+// DCLR operations (AC bits 10-11)
+const (
+	RK_CLR_STATUS  = 0 // Clear status
+	RK_CLR_CONTROL = 1 // Clear the controller
+	RK_CLR_DRIVE   = 2 // Reset the drive, recalibrating it to cylinder 0
+	RK_CLR_STATUS2 = 3 // Clear status
+)
+
+// Command register
+const (
+	RK_CMD_FUNC  = 0o7000 // Function
+	RK_CMD_IE    = 0o0400 // Interrupt on done
+	RK_CMD_SKDN  = 0o0200 // Set done when a seek has finished
+	RK_CMD_HALF  = 0o0100 // Transfer 128 words instead of 256
+	RK_CMD_FIELD = 0o0070 // Memory field
+	RK_CMD_DRIVE = 0o0006 // Drive select
+	RK_CMD_CYL   = 0o0001 // Most significant bit of the cylinder
+)
+
+// Command register functions
+const (
+	RK_READ      = 0
+	RK_READALL   = 1
+	RK_WRITELOCK = 2
+	RK_SEEK      = 3
+	RK_WRITE     = 4
+	RK_WRITEALL  = 5
+)
+
+// Status register
+const (
+	RK_STA_DONE   = 0o4000 // Transfer done
+	RK_STA_HMOV   = 0o2000 // Heads moving
+	RK_STA_SKFL   = 0o0400 // Drive seek fail
+	RK_STA_NRDY   = 0o0200 // Drive not ready
+	RK_STA_BUSY   = 0o0100 // Control busy error
+	RK_STA_TMO    = 0o0040 // Timing error
+	RK_STA_WLK    = 0o0020 // Write lock error
+	RK_STA_CRC    = 0o0010 // CRC error
+	RK_STA_DLT    = 0o0004 // Data late
+	RK_STA_STATUS = 0o0002 // Drive status error
+	RK_STA_CYL    = 0o0001 // Cylinder address error
+	RK_STA_ERR    = RK_STA_BUSY | RK_STA_TMO | RK_STA_WLK | RK_STA_CRC | RK_STA_DLT | RK_STA_STATUS | RK_STA_CYL
+)
+
+// RK05 geometry. A disk address is the cylinder, surface and sector, which is
+// also the block number.
+const (
+	RK_DRIVES    = 4
+	RK_CYLINDERS = 203
+	RK_SECTORS   = 16
+	RK_SURFACES  = 2
+	RK_BLOCKS    = RK_CYLINDERS * RK_SURFACES * RK_SECTORS
+	RK_WORDS     = 256 // Words in a sector
+)
+
+// RK05 timing
+const (
+	RK_seekSettle = 5 * time.Millisecond   // Track to track seek
+	RK_seekTime   = 250 * time.Microsecond // More for each cylinder crossed
+	RK_rotation   = 40 * time.Millisecond  // 1500 rpm
+)
+
+// RK8EDevice is an RK8-E disk controller with up to four RK05 cartridge disk
+// drives. Each RK05 holds 203 cylinders of two surfaces with 16 sectors of 256
+// words, stored in .rk05 image files. Data is transferred by single cycle data
+// break to the current address in the field given by the command register.
+type RK8EDevice struct {
+	Device
+
+	CMD uint16 // Command register
+	DA  uint16 // Disk address register
+	CA  uint16 // Current address register
+	STA uint16 // Status register
+
+	busy bool
+
+	drives [RK_DRIVES]rk05
+
+	mk *MK12
+	ac uint16
+}
+
+// An RK05 drive
+type rk05 struct {
+	img *diskImage
+
+	// Cylinder the heads are on, and whether they are moving
+	cyl    int
+	moving bool
+
+	// Set by the write lock command until the pack is detached
+	locked bool
+}
+
+func NewRK8E() Storage {
+	return &RK8EDevice{}
+}
+
+func init() {
+	storageControllers["rk"] = NewRK8E
+	loaders["rk"] = Loader{
+		Help:    "RK8E bootstrap, reads block 0 of rk0 into 0000",
+		Origin:  0o23,
+		Start:   0o23,
+		Words:   rkBoot,
+		SetUnit: func(words []uint16, unit int) { words[7] = uint16(unit) << 1 },
+	}
+}
+
+// The standard RK8E bootstrap. It reads block 0 into 0000-0377 of field 0,
+// loading over the JMP . it waits in.
+var rkBoot = []uint16{
+	0o6007, // 23       CAF
+	0o6744, // 24       DLCA            / Current address 0
+	0o1032, // 25       TAD UNIT
+	0o6746, // 26       DLDC            / Read to field 0
+	0o6743, // 27       DLAG            / Block 0
+	0o1032, // 30       TAD UNIT
+	0o5031, // 31       JMP .
+	0o0000, // 32 UNIT, 0               / Drive 0, 2 for drive 1 and so on
+}
+
+func (rk *RK8EDevice) DriveName() string {
+	return "rk"
+}
+
+func (rk *RK8EDevice) Drives() int {
+	return RK_DRIVES
+}
+
+func (rk *RK8EDevice) Attach(drive int, path string, readOnly bool) error {
+	img, err := openImage(path, readOnly)
+	if err != nil {
+		return err
+	}
+	rk.drives[drive] = rk05{img: img}
+	return nil
+}
+
+func (rk *RK8EDevice) Detach(drive int) error {
+	d := &rk.drives[drive]
+	if d.img == nil {
+		return fmt.Errorf("nothing is attached to rk%d", drive)
+	}
+	err := d.img.Close()
+	*d = rk05{}
+	return err
+}
+
+func (rk *RK8EDevice) Image(drive int) *diskImage {
+	return rk.drives[drive].img
+}
+
+func (rk *RK8EDevice) Select(addr uint16, mk *MK12) bool {
+	if addr != RK_DEV {
+		return false
+	}
+	rk.mk = mk
+	rk.ac = mk.AC
+	return true
+}
+
+func (rk *RK8EDevice) Get() uint16 {
+	return rk.STA
+}
+
+func (rk *RK8EDevice) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch fn {
+	case DSKP:
+		skip = rk.STA&(RK_STA_DONE|RK_STA_ERR) != 0
+
+	case DCLR:
+		clr = true
+		rk.STA = 0
+		switch rk.ac & 0o3 {
+		case RK_CLR_STATUS, RK_CLR_STATUS2:
+			if rk.busy {
+				rk.STA |= RK_STA_BUSY
+			}
+		case RK_CLR_CONTROL:
+			rk.CMD, rk.DA, rk.CA = 0, 0, 0
+			rk.busy = false
+		case RK_CLR_DRIVE:
+			if rk.busy {
+				rk.STA |= RK_STA_BUSY
+			} else {
+				rk.start(RK_SEEK, 0)
+			}
+		}
+
+	case DLAG:
+		clr = true
+		if rk.busy {
+			rk.STA |= RK_STA_BUSY
+			break
+		}
+		rk.DA = rk.ac
+		rk.start(rk.CMD>>9, rk.block()>>5)
+
+	case DLCA:
+		clr = true
+		if rk.busy {
+			rk.STA |= RK_STA_BUSY
+			break
+		}
+		rk.CA = rk.ac
+
+	case DRST:
+		clr, or = true, true
+
+	case DLDC:
+		clr = true
+		if rk.busy {
+			rk.STA |= RK_STA_BUSY
+			break
+		}
+		rk.CMD = rk.ac
+		rk.STA = 0
+
+	case DMAN:
+		// Loads the maintenance register, which only the diagnostics use.
+		// Maintenance mode isn't simulated, so AC is left alone and the
+		// controller carries on as it was.
+	}
+	return
+}
+
+// Block number from the cylinder extension bit and the disk address
+func (rk *RK8EDevice) block() int {
+	return int(rk.CMD&RK_CMD_CYL)<<12 | int(rk.DA)
+}
+
+func (rk *RK8EDevice) drive() *rk05 {
+	return &rk.drives[(rk.CMD&RK_CMD_DRIVE)>>1]
+}
+
+// Starts a function on the selected drive, the heads first move to cyl
+func (rk *RK8EDevice) start(fn uint16, cyl int) {
+	d := rk.drive()
+	switch {
+	case d.img == nil:
+		rk.STA |= RK_STA_DONE | RK_STA_NRDY | RK_STA_STATUS
+		return
+	case d.moving:
+		rk.STA |= RK_STA_DONE | RK_STA_STATUS
+		return
+	case cyl >= RK_CYLINDERS:
+		rk.STA |= RK_STA_DONE | RK_STA_CYL
+		return
+	}
+
+	switch fn {
+	case RK_WRITELOCK:
+		d.locked = true
+		rk.STA |= RK_STA_DONE
+		return
+	case RK_WRITE, RK_WRITEALL:
+		if d.locked || d.img.ReadOnly {
+			rk.STA |= RK_STA_DONE | RK_STA_WLK
+			return
+		}
+	}
+
+	// Seek, then wait for the sector to come round
+	delay := RK_seekSettle + time.Duration(abs(cyl-d.cyl))*RK_seekTime
+	if cyl == d.cyl {
+		delay = 0
+	}
+	d.cyl = cyl
+	d.moving = true
+
+	if fn == RK_SEEK {
+		// The controller is free as soon as the seek has started
+		rk.STA |= RK_STA_DONE
+		rk.mk.schedule(delay, func() {
+			d.moving = false
+			if rk.CMD&RK_CMD_SKDN != 0 {
+				rk.STA |= RK_STA_DONE
+			}
+		})
+		return
+	}
+	rk.busy = true
+	rk.mk.schedule(delay+RK_rotation/2, func() {
+		d.moving = false
+		// Unless the controller has been cleared in the meantime
+		if rk.busy {
+			rk.transfer(fn, d)
+		}
+	})
+}
+
+// Moves a sector between memory and the disk once it is under the heads
+func (rk *RK8EDevice) transfer(fn uint16, d *rk05) {
+	rk.busy = false
+	if d.img == nil {
+		rk.STA |= RK_STA_DONE | RK_STA_NRDY | RK_STA_STATUS
+		return
+	}
+	words := RK_WORDS
+	if rk.CMD&RK_CMD_HALF != 0 {
+		words = RK_WORDS / 2
+	}
+	field := (rk.CMD & RK_CMD_FIELD) >> 3
+	buf := make([]uint16, RK_WORDS)
+	off := int64(rk.block()) * RK_WORDS
+
+	switch fn {
+	case RK_READ, RK_READALL:
+		if err := d.img.ReadWords(off, buf[:words]); err != nil {
+			rk.STA |= RK_STA_DONE | RK_STA_CRC
+			return
+		}
+		for i := 0; i < words; i++ {
+			rk.mk.breakIn(field<<12|rk.CA, buf[i])
+			rk.CA = (rk.CA + 1) & 0o7777
+		}
+	case RK_WRITE, RK_WRITEALL:
+		// A half sector write fills the rest of the sector with zeros
+		for i := 0; i < words; i++ {
+			buf[i] = rk.mk.breakOut(field<<12 | rk.CA)
+			rk.CA = (rk.CA + 1) & 0o7777
+		}
+		if err := d.img.WriteWords(off, buf); err != nil {
+			rk.STA |= RK_STA_DONE | RK_STA_CRC
+			return
+		}
+	}
+	rk.STA |= RK_STA_DONE
+}
+
+func (rk *RK8EDevice) InterruptRequest() bool {
+	return rk.CMD&RK_CMD_IE != 0 && rk.STA&(RK_STA_DONE|RK_STA_ERR) != 0
+}
+
+// CAF clears the controller, as DCLR with AC 0001 does
+func (rk *RK8EDevice) ClearFlags() {
+	rk.CMD, rk.DA, rk.CA, rk.STA = 0, 0, 0, 0
+	rk.busy = false
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RX8E floppy disk interface device code
+const RX_DEV = 0o75
+
+// RX8E function codes
+const (
+	LCD  = 0o1 // Load command register from AC, clear AC
+	XDR  = 0o2 // Transfer data register to or from AC
+	STR  = 0o3 // Skip on transfer request flag, clear it
+	SER  = 0o4 // Skip on error flag, clear it
+	SDN  = 0o5 // Skip on done flag, clear it
+	INTR = 0o6 // Set interrupt enable from AC bit 11
+	INIT = 0o7 // Initialize the interface and drives
+)
+
+// Command register
+const (
+	RX_CMD_MAINT = 0o200 // Maintenance
+	RX_CMD_8BIT  = 0o100 // 8-bit mode, bytes are transferred instead of 12-bit words
+	RX_CMD_DRIVE = 0o020 // Drive select
+	RX_CMD_FUNC  = 0o016 // Function
+	RX_CMD_WRITE = 0o376 // Bits kept in the register
+)
+
+// Command register functions
+const (
+	RX_FILL    = 0 // Fill the silo from the program
+	RX_EMPTY   = 1 // Empty the silo to the program
+	RX_WRITE   = 2 // Write the silo to a sector
+	RX_READ    = 3 // Read a sector into the silo
+	RX_NOP     = 4 // Unused on the RX01
+	RX_STATUS  = 5 // Read the error and status register
+	RX_WRDEL   = 6 // Write the silo to a sector with a deleted data mark
+	RX_ERRCODE = 7 // Read the error code
+)
+
+// Error and status register
+const (
+	RX_ES_CRC  = 0o001 // CRC error
+	RX_ES_ID   = 0o004 // Initialize done
+	RX_ES_DD   = 0o100 // Deleted data mark
+	RX_ES_DRDY = 0o200 // Drive ready
+)
+
+// Error codes
+const (
+	RX_ERR_TRACK  = 0o040 // Track above 76
+	RX_ERR_SECTOR = 0o070 // Sector not found
+	RX_ERR_WRPROT = 0o100 // Write protected
+	RX_ERR_NOTRDY = 0o110 // No diskette in the drive
+	RX_ERR_CRC    = 0o130 // CRC error in the data field
+)
+
+// RX01 geometry, sectors are numbered from 1. Images (.rx01) hold the bytes
+// of each sector in turn, track by track.
+const (
+	RX_DRIVES  = 2
+	RX_TRACKS  = 77
+	RX_SECTORS = 26
+	RX_BYTES   = 128 // Bytes in a sector and the silo
+	RX_WORDS   = 64  // 12-bit words in the silo, packed into 96 bytes
+)
+
+// RX01 timing
+const (
+	RX_xferTime = 20 * time.Microsecond  // Moving a word or byte through the data register
+	RX_stepTime = 10 * time.Millisecond  // Stepping the head one track
+	RX_settle   = 20 * time.Millisecond  // Head settling after a seek
+	RX_rotation = 166 * time.Millisecond // 360 rpm
+)
+
+// What the interface expects XDR to do next
+const (
+	rxIdle   = iota // Read the data register
+	rxFill          // Take a word or byte for the silo
+	rxEmpty         // Give a word or byte from the silo
+	rxSector        // Take the sector address
+	rxTrack         // Take the track address, then start the transfer
+	rxBusy          // Seeking, reading or writing
+)
+
+// RX8EDevice is an RX8E interface with up to two RX01 floppy disk drives. A
+// command is loaded with LCD and then words pass through the data register
+// with XDR, the program waiting for the transfer request flag between them:
+// 64 words (or 128 bytes in 8-bit mode) to fill or empty the silo, or the
+// sector and track to read or write. The done flag is set when a command has
+// finished, with the error flag as well if it failed.
+type RX8EDevice struct {
+	Device
+
+	CMD uint16 // Command register
+	DB  uint16 // Data buffer register
+	ES  uint16 // Error and status register
+
+	// Silo, and the next word or byte in it
+	silo [RX_BYTES]byte
+	ptr  int
+
+	sector, track uint16
+	errCode       uint16
+
+	// Flags and interrupt enable
+	TR, ERR, DONE bool
+	IE            bool
+
+	state int
+
+	drives [RX_DRIVES]rx01
+
+	mk *MK12
+	ac uint16
+}
+
+// An RX01 drive
+type rx01 struct {
+	img   *diskImage
+	track uint16
+}
+
+// The interface powers up as though it had just been initialized
+func NewRX8E() Storage {
+	return &RX8EDevice{ES: RX_ES_ID, DONE: true}
+}
+
+func init() {
+	storageControllers["rx"] = NewRX8E
+	loaders["rx"] = Loader{
+		Help:   "RX8E bootstrap, reads track 1 sector 1 of rx0 in 12-bit mode",
+		Origin: 0o22,
+		Start:  0o22,
+		Words:  rxBoot,
+		SetUnit: func(words []uint16, unit int) {
+			words[len(words)-1] |= uint16(unit) << 4
+		},
+	}
+}
+
+// The standard RX8E 12-bit bootstrap. It reads track 1 sector 1 of drive 0
+// and empties the silo over itself starting at location 2, so the sector
+// takes over once it has been loaded.
+var rxBoot = []uint16{
+	0o6755, // 22       SDN             / Wait for the power up initialize
+	0o5022, // 23       JMP .-1
+	0o7326, // 24       CLA CLL CML RTL / Read command
+	0o1060, // 25       TAD UNIT
+	0o6751, // 26       LCD
+	0o7201, // 27       CLA IAC         / Sector 1
+	0o4053, // 30       JMS LOAD
+	0o4053, // 31       JMS LOAD        / Track 1
+	0o7104, // 32       CLL RAL         / Empty command
+	0o6755, // 33       SDN
+	0o5054, // 34       JMP LOOP
+	0o6754, // 35       SER
+	0o7450, // 36       SNA
+	0o7610, // 37       SKP CLA
+	0o5046, // 40       JMP 46          / Read done, empty the silo
+	0o7402, // 41       HLT             / Error
+	0o7402, // 42       HLT
+	0o7402, // 43       HLT
+	0o7402, // 44       HLT
+	0o7402, // 45       HLT
+	0o6751, // 46       LCD
+	0o4053, // 47       JMS LOAD
+	0o3002, // 50       DCA 2
+	0o2050, // 51       ISZ 50
+	0o5047, // 52       JMP 47
+	0o0000, // 53 LOAD, 0
+	0o6753, // 54 LOOP, STR
+	0o5033, // 55       JMP 33
+	0o6752, // 56       XDR
+	0o5453, // 57       JMP I LOAD
+	0o7004, // 60 UNIT, 7004            / Drive 0, 7024 for drive 1
+}
+
+func (rx *RX8EDevice) DriveName() string {
+	return "rx"
+}
+
+func (rx *RX8EDevice) Drives() int {
+	return RX_DRIVES
+}
+
+func (rx *RX8EDevice) Attach(drive int, path string, readOnly bool) error {
+	img, err := openImage(path, readOnly)
+	if err != nil {
+		return err
+	}
+	rx.drives[drive] = rx01{img: img}
+	return nil
+}
+
+func (rx *RX8EDevice) Detach(drive int) error {
+	d := &rx.drives[drive]
+	if d.img == nil {
+		return fmt.Errorf("nothing is attached to rx%d", drive)
+	}
+	err := d.img.Close()
+	*d = rx01{}
+	return err
+}
+
+func (rx *RX8EDevice) Image(drive int) *diskImage {
+	return rx.drives[drive].img
+}
+
+func (rx *RX8EDevice) Select(addr uint16, mk *MK12) bool {
+	if addr != RX_DEV {
+		return false
+	}
+	rx.mk = mk
+	rx.ac = mk.AC
+	return true
+}
+
+// XDR reads the data register. In 8-bit mode only AC bits 4-11 are loaded,
+// the rest of AC is kept.
+func (rx *RX8EDevice) Get() uint16 {
+	if rx.CMD&RX_CMD_8BIT != 0 {
+		return rx.ac&0o7400 | rx.DB&0o377
+	}
+	return rx.DB
+}
+
+func (rx *RX8EDevice) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch fn {
+	case LCD:
+		clr = true
+		if rx.state == rxIdle {
+			rx.command(rx.ac & RX_CMD_WRITE)
+		}
+
+	case XDR:
+		or = rx.xdr()
+		clr = or
+
+	case STR:
+		skip = rx.TR
+		rx.TR = false
+
+	case SER:
+		skip = rx.ERR
+		rx.ERR = false
+
+	case SDN:
+		skip = rx.DONE
+		rx.DONE = false
+
+	case INTR:
+		rx.IE = rx.ac&1 != 0
+
+	case INIT:
+		rx.initialize()
+	}
+	return
+}
+
+func (rx *RX8EDevice) drive() *rx01 {
+	return &rx.drives[(rx.CMD&RX_CMD_DRIVE)>>4]
+}
+
+func (rx *RX8EDevice) words() bool {
+	return rx.CMD&RX_CMD_8BIT == 0
+}
+
+// Starts the command loaded by LCD
+func (rx *RX8EDevice) command(cmd uint16) {
+	rx.CMD = cmd
+	rx.DONE = false
+	rx.ptr = 0
+	switch (cmd & RX_CMD_FUNC) >> 1 {
+	case RX_FILL:
+		rx.state = rxFill
+		rx.request()
+	case RX_EMPTY:
+		rx.state = rxEmpty
+		rx.DB = rx.next()
+		rx.request()
+	case RX_READ, RX_WRITE, RX_WRDEL:
+		rx.state = rxSector
+		rx.request()
+	case RX_ERRCODE:
+		code := rx.errCode
+		rx.done(0)
+		rx.DB = code
+	default:
+		rx.done(0)
+	}
+}
+
+// Sets the transfer request flag once the data register is ready
+func (rx *RX8EDevice) request() {
+	state := rx.state
+	rx.mk.schedule(RX_xferTime, func() {
+		if rx.state == state {
+			rx.TR = true
+		}
+	})
+}
+
+// XDR, returning true if the data register should be read into AC
+func (rx *RX8EDevice) xdr() bool {
+	switch rx.state {
+	case rxFill:
+		rx.put(rx.ac)
+		if rx.full() {
+			// What is left of the silo after 64 words is zeros
+			for i := rx.ptr; i < RX_BYTES; i++ {
+				rx.silo[i] = 0
+			}
+			rx.done(0)
+		} else {
+			rx.request()
+		}
+		return false
+
+	case rxEmpty:
+		// The data register already holds the word, the next is fetched once
+		// the program has taken it. After the last one the command finishes,
+		// leaving the word in the data register for this XDR.
+		if rx.full() {
+			rx.mk.schedule(RX_xferTime, func() {
+				if rx.state == rxEmpty {
+					rx.done(0)
+				}
+			})
+		} else {
+			rx.mk.schedule(RX_xferTime, func() {
+				if rx.state == rxEmpty {
+					rx.DB = rx.next()
+					rx.TR = true
+				}
+			})
+		}
+		return true
+
+	case rxSector:
+		rx.sector = rx.ac & 0o177
+		rx.state = rxTrack
+		rx.request()
+		return false
+
+	case rxTrack:
+		rx.track = rx.ac & 0o377
+		rx.state = rxBusy
+		rx.seek()
+		return false
+
+	case rxBusy:
+		return false
+	}
+	return true
+}
+
+// Stores a word or byte from the program in the silo
+func (rx *RX8EDevice) put(w uint16) {
+	if !rx.words() {
+		rx.silo[rx.ptr] = byte(w)
+		rx.ptr++
+		return
+	}
+	// Two 12-bit words are packed into three bytes
+	w &= 0o7777
+	if rx.ptr%3 == 0 {
+		rx.silo[rx.ptr] = byte(w >> 4)
+		rx.silo[rx.ptr+1] = byte(w&0o17) << 4
+		rx.ptr++
+	} else {
+		rx.silo[rx.ptr] |= byte(w >> 8)
+		rx.silo[rx.ptr+1] = byte(w)
+		rx.ptr += 2
+	}
+}
+
+// Returns the next word or byte from the silo
+func (rx *RX8EDevice) next() uint16 {
+	if !rx.words() {
+		b := uint16(rx.silo[rx.ptr])
+		rx.ptr++
+		return b
+	}
+	var w uint16
+	if rx.ptr%3 == 0 {
+		w = uint16(rx.silo[rx.ptr])<<4 | uint16(rx.silo[rx.ptr+1])>>4
+		rx.ptr++
+	} else {
+		w = uint16(rx.silo[rx.ptr]&0o17)<<8 | uint16(rx.silo[rx.ptr+1])
+		rx.ptr += 2
+	}
+	return w
+}
+
+// Whether the silo has been filled or emptied
+func (rx *RX8EDevice) full() bool {
+	if rx.words() {
+		return rx.ptr >= RX_WORDS*3/2
+	}
+	return rx.ptr >= RX_BYTES
+}
+
+// Moves the head to the track, then waits for the sector to come round
+func (rx *RX8EDevice) seek() {
+	d := rx.drive()
+	if d.img == nil {
+		rx.done(RX_ERR_NOTRDY)
+		return
+	}
+	if rx.track >= RX_TRACKS {
+		rx.done(RX_ERR_TRACK)
+		return
+	}
+	delay := RX_rotation / 2
+	if rx.track != d.track {
+		delay += time.Duration(abs(int(rx.track)-int(d.track)))*RX_stepTime + RX_settle
+	}
+	d.track = rx.track
+	fn := (rx.CMD & RX_CMD_FUNC) >> 1
+	rx.mk.schedule(delay, func() {
+		if rx.state == rxBusy {
+			rx.transfer(fn, d)
+		}
+	})
+}
+
+// Reads or writes the sector once it is under the head
+func (rx *RX8EDevice) transfer(fn uint16, d *rx01) {
+	if d.img == nil {
+		rx.done(RX_ERR_NOTRDY)
+		return
+	}
+	if rx.sector < 1 || rx.sector > RX_SECTORS {
+		rx.done(RX_ERR_SECTOR)
+		return
+	}
+	off := (int64(rx.track)*RX_SECTORS + int64(rx.sector) - 1) * RX_BYTES
+	if fn == RX_READ {
+		if err := d.img.ReadBytes(off, rx.silo[:]); err != nil {
+			rx.done(RX_ERR_CRC)
+			return
+		}
+		rx.done(0)
+		return
+	}
+	// Deleted data marks aren't kept in images, so they read back as data
+	if d.img.ReadOnly {
+		rx.done(RX_ERR_WRPROT)
+		return
+	}
+	if err := d.img.WriteBytes(off, rx.silo[:]); err != nil {
+		rx.done(RX_ERR_CRC)
+		return
+	}
+	rx.done(0)
+}
+
+// Finishes a command, failed if code is not 0. The data register is left
+// holding the status.
+func (rx *RX8EDevice) done(code uint16) {
+	rx.state = rxIdle
+	rx.TR = false
+	rx.errCode = code
+	rx.ES &^= RX_ES_DRDY | RX_ES_CRC
+	if rx.drive().img != nil {
+		rx.ES |= RX_ES_DRDY
+	}
+	if code == RX_ERR_CRC {
+		rx.ES |= RX_ES_CRC
+	}
+	rx.DB = rx.ES
+	rx.ERR = code != 0
+	rx.DONE = true
+}
+
+// INIT recalibrates drive 0 to track 0 and then reads track 1 sector 1
+// into the silo
+func (rx *RX8EDevice) initialize() {
+	rx.CMD, rx.ES = 0, 0
+	rx.TR, rx.ERR, rx.DONE, rx.IE = false, false, false, false
+	rx.state = rxBusy
+	d := &rx.drives[0]
+	delay := time.Duration(d.track+1)*RX_stepTime + RX_settle + RX_rotation/2
+	d.track = 1
+	rx.mk.schedule(delay, func() {
+		if rx.state != rxBusy {
+			return
+		}
+		if d.img != nil {
+			d.img.ReadBytes(RX_SECTORS*RX_BYTES, rx.silo[:])
+		}
+		rx.ES = RX_ES_ID
+		rx.done(0)
+	})
+}
+
+// Done, or an error, requests an interrupt
+func (rx *RX8EDevice) InterruptRequest() bool {
+	return rx.IE && rx.DONE
+}
+
+// CAF initializes the interface
+func (rx *RX8EDevice) ClearFlags() {
+	if rx.mk != nil {
+		rx.initialize()
+	}
+}
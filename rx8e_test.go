@@ -0,0 +1,63 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Fills the silo, writes it to a sector, reads it back and empties it
+func testRXSector(t *testing.T, eightBit bool) {
+	mk := newTestMK12(t)
+	rx := NewRX8E().(*RX8EDevice)
+	if err := rx.Attach(0, filepath.Join(t.TempDir(), "test.rx01"), false); err != nil {
+		t.Fatal(err)
+	}
+	var mode uint16
+	n, mask := RX_WORDS, uint16(0o7777)
+	if eightBit {
+		mode, n, mask = RX_CMD_8BIT, RX_BYTES, 0o377
+	}
+	xdr := func(ac uint16) uint16 {
+		waitTest(t, mk, time.Second, func() bool { return testIOT(mk, rx, RX_DEV, STR, 0) })
+		testIOT(mk, rx, RX_DEV, XDR, ac)
+		return mk.AC
+	}
+	finish := func() {
+		waitTest(t, mk, time.Second, func() bool { return testIOT(mk, rx, RX_DEV, SDN, 0) })
+		if testIOT(mk, rx, RX_DEV, SER, 0) {
+			t.Fatalf("error %o", rx.errCode)
+		}
+	}
+
+	data := make([]uint16, n)
+	for i := range data {
+		data[i] = uint16(i*0o1235+0o4567) & mask
+	}
+	testIOT(mk, rx, RX_DEV, LCD, mode|RX_FILL<<1)
+	for _, w := range data {
+		xdr(w)
+	}
+	finish()
+	for _, fn := range []uint16{RX_WRITE, RX_READ} {
+		testIOT(mk, rx, RX_DEV, LCD, mode|fn<<1)
+		xdr(7)
+		xdr(3)
+		finish()
+	}
+	testIOT(mk, rx, RX_DEV, LCD, mode|RX_EMPTY<<1)
+	for i, w := range data {
+		if got := xdr(0) & mask; got != w {
+			t.Errorf("word %d is %04o, want %04o", i, got, w)
+		}
+	}
+	finish()
+}
+
+func TestRXSector12Bit(t *testing.T) {
+	testRXSector(t, false)
+}
+
+func TestRXSector8Bit(t *testing.T) {
+	testRXSector(t, true)
+}
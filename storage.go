@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Mass storage controllers implement Storage so image files can be attached
+// to their drives from the command line and the debug console. Drives are
+// named by the controller's drive name and unit number, rk0-rk3 for example.
+type Storage interface {
+	Device
+
+	// Name of the controller's drives, without the unit number
+	DriveName() string
+
+	// Number of drives the controller has
+	Drives() int
+
+	// Attaches the image file at path to a drive, read only if readOnly is set
+	Attach(drive int, path string, readOnly bool) error
+
+	// Detaches the image file from a drive
+	Detach(drive int) error
+
+	// Returns the image attached to a drive, nil if none
+	Image(drive int) *diskImage
+}
+
+// Creates a controller by drive name. A controller is installed the first
+// time one of its drives is attached.
+var storageControllers = map[string]func() Storage{}
+
+// Controllers that use the same device codes and can't be installed together
+var storageConflicts = map[string][]string{}
+
+// An image file attached to a drive. Images hold 12-bit words in 16-bit little
+// endian words like SIMH, reads past the end of the file return zeros and
+// writes extend it.
+type diskImage struct {
+	f        *os.File
+	Path     string
+	ReadOnly bool
+}
+
+// Opens an image, creating it if it doesn't exist and isn't read only
+func openImage(path string, readOnly bool) (*diskImage, error) {
+	var f *os.File
+	var err error
+	if readOnly {
+		f, err = os.Open(path)
+	} else {
+		f, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &diskImage{f: f, Path: path, ReadOnly: readOnly}, nil
+}
+
+// Reads len(buf) words starting at word offset off
+func (img *diskImage) ReadWords(off int64, buf []uint16) error {
+	b := make([]byte, 2*len(buf))
+	n, err := img.f.ReadAt(b, 2*off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	for i := n; i < len(b); i++ {
+		b[i] = 0
+	}
+	for i := range buf {
+		buf[i] = binary.LittleEndian.Uint16(b[2*i:]) & 0o7777
+	}
+	return nil
+}
+
+// Writes buf as words starting at word offset off
+func (img *diskImage) WriteWords(off int64, buf []uint16) error {
+	if img.ReadOnly {
+		return fmt.Errorf("%s is read only", img.Path)
+	}
+	b := make([]byte, 2*len(buf))
+	for i, w := range buf {
+		binary.LittleEndian.PutUint16(b[2*i:], w&0o7777)
+	}
+	_, err := img.f.WriteAt(b, 2*off)
+	return err
+}
+
+// Reads len(buf) bytes starting at byte offset off, for 8-bit media
+func (img *diskImage) ReadBytes(off int64, buf []byte) error {
+	n, err := img.f.ReadAt(buf, off)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	for i := n; i < len(buf); i++ {
+		buf[i] = 0
+	}
+	return nil
+}
+
+// Writes buf starting at byte offset off
+func (img *diskImage) WriteBytes(off int64, buf []byte) error {
+	if img.ReadOnly {
+		return fmt.Errorf("%s is read only", img.Path)
+	}
+	_, err := img.f.WriteAt(buf, off)
+	return err
+}
+
+func (img *diskImage) Close() error {
+	return img.f.Close()
+}
+
+// Splits a drive name like rk0 into the controller name and unit number
+func parseDrive(name string) (string, int, error) {
+	i := strings.IndexAny(name, "0123456789")
+	if i <= 0 {
+		return "", 0, fmt.Errorf("bad drive name %q", name)
+	}
+	unit, err := strconv.Atoi(name[i:])
+	if err != nil {
+		return "", 0, fmt.Errorf("bad drive name %q", name)
+	}
+	return strings.ToLower(name[:i]), unit, nil
+}
+
+// Returns the installed controller for a drive name, installing it if need be
+func (mk *MK12) storage(name string) (Storage, error) {
+	for _, dev := range mk.IOT {
+		if s, ok := dev.(Storage); ok && s.DriveName() == name {
+			return s, nil
+		}
+	}
+	create, ok := storageControllers[name]
+	if !ok {
+		return nil, fmt.Errorf("no controller has drives called %s", name)
+	}
+	for _, other := range storageConflicts[name] {
+		for _, dev := range mk.IOT {
+			if s, ok := dev.(Storage); ok && s.DriveName() == other {
+				return nil, fmt.Errorf("%s drives can't be used with %s drives installed", name, other)
+			}
+		}
+	}
+	s := create()
+	mk.IOT = append(mk.IOT, s)
+	return s, nil
+}
+
+// Attaches an image file to a drive such as rk0
+func (mk *MK12) attach(drive string, path string, readOnly bool) error {
+	name, unit, err := parseDrive(drive)
+	if err != nil {
+		return err
+	}
+	s, err := mk.storage(name)
+	if err != nil {
+		return err
+	}
+	if unit >= s.Drives() {
+		return fmt.Errorf("%s has drives %s0-%s%d", name, name, name, s.Drives()-1)
+	}
+	if s.Image(unit) != nil {
+		if err := s.Detach(unit); err != nil {
+			return err
+		}
+	}
+	return s.Attach(unit, path, readOnly)
+}
+
+// Detaches the image file from a drive
+func (mk *MK12) detach(drive string) error {
+	name, unit, err := parseDrive(drive)
+	if err != nil {
+		return err
+	}
+	for _, dev := range mk.IOT {
+		if s, ok := dev.(Storage); ok && s.DriveName() == name {
+			if unit >= s.Drives() || s.Image(unit) == nil {
+				return fmt.Errorf("nothing is attached to %s", drive)
+			}
+			return s.Detach(unit)
+		}
+	}
+	return fmt.Errorf("nothing is attached to %s", drive)
+}
+
+// Attaches the images given with -attach as drive=path[,ro]
+func (mk *MK12) attachImages(specs []string) error {
+	for _, spec := range specs {
+		drive, path, ok := strings.Cut(spec, "=")
+		if !ok || path == "" {
+			return fmt.Errorf("image %q is not drive=path", spec)
+		}
+		readOnly := false
+		if strings.HasSuffix(path, ",ro") {
+			path, readOnly = strings.TrimSuffix(path, ",ro"), true
+		}
+		if err := mk.attach(drive, path, readOnly); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flushes and closes every attached image before the simulator exits
+func (mk *MK12) detachAll() {
+	for _, dev := range mk.IOT {
+		if s, ok := dev.(Storage); ok {
+			for unit := 0; unit < s.Drives(); unit++ {
+				if s.Image(unit) != nil {
+					s.Detach(unit)
+				}
+			}
+		}
+	}
+}
+
+func init() {
+	debugCommands["attach"] = DebugCommand{
+		Usage: "[drive path [ro]]",
+		Help:  "Attach an image file to a drive such as rk0, or list attached images",
+		Run:   cmdAttach,
+		Files: true,
+	}
+	debugCommands["detach"] = DebugCommand{
+		Usage: "<drive>",
+		Help:  "Detach the image file from a drive",
+		Run:   cmdDetach,
+		Files: true,
+	}
+}
+
+func cmdAttach(mk *MK12, args []string) (string, error) {
+	switch len(args) {
+	case 0:
+		var lines []string
+		for _, dev := range mk.IOT {
+			s, ok := dev.(Storage)
+			if !ok {
+				continue
+			}
+			for unit := 0; unit < s.Drives(); unit++ {
+				if img := s.Image(unit); img != nil {
+					line := fmt.Sprintf("%s%d %s", s.DriveName(), unit, img.Path)
+					if img.ReadOnly {
+						line += " (read only)"
+					}
+					lines = append(lines, line)
+				}
+			}
+		}
+		if len(lines) == 0 {
+			return "nothing attached", nil
+		}
+		sort.Strings(lines)
+		return strings.Join(lines, "\n"), nil
+	case 2, 3:
+		readOnly := len(args) == 3
+		if readOnly && args[2] != "ro" {
+			return "", fmt.Errorf("usage: attach <drive> <path> [ro]")
+		}
+		return "", mk.attach(args[0], args[1], readOnly)
+	}
+	return "", fmt.Errorf("usage: attach <drive> <path> [ro]")
+}
+
+func cmdDetach(mk *MK12, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("usage: detach <drive>")
+	}
+	return "", mk.detach(args[0])
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// TC08 DECtape controller device codes
+const (
+	DT_DEV_A = 0o76 // Status register A
+	DT_DEV_B = 0o77 // Status register B and the DECtape flag
+)
+
+// TC08 function codes, device 76
+const (
+	DTRA = 0o1 // OR status register A into AC
+	DTCA = 0o2 // Clear status register A
+	DTXA = 0o4 // XOR AC into status register A, clear AC
+)
+
+// TC08 function codes, device 77
+const (
+	DTSF = 0o1 // Skip on DECtape flag or error flag
+	DTRB = 0o2 // OR status register B into AC
+	DTLB = 0o4 // Load the memory field from AC, clear AC
+)
+
+// Status register A
+const (
+	DT_STA_UNIT  = 0o7000 // Unit select
+	DT_STA_REV   = 0o0400 // Reverse
+	DT_STA_GO    = 0o0200 // Tape in motion
+	DT_STA_MODE  = 0o0100 // Continuous mode, the flag is set by word count overflow
+	DT_STA_FUNC  = 0o0070 // Function
+	DT_STA_ENB   = 0o0004 // Interrupt enable
+	DT_STA_CERF  = 0o0002 // Unless set, DTXA clears the error flags
+	DT_STA_CDTF  = 0o0001 // Unless set, DTXA clears the DECtape flag
+	DT_STA_WRITE = 0o7774 // Bits kept in the register
+)
+
+// Status register A functions
+const (
+	DT_MOVE     = 0
+	DT_SEARCH   = 1
+	DT_READ     = 2
+	DT_READALL  = 3
+	DT_WRITE    = 4
+	DT_WRITEALL = 5
+	DT_TIMING   = 6 // Write timing and mark track, not supported
+)
+
+// Status register B
+const (
+	DT_STB_ERF   = 0o4000 // Error flag
+	DT_STB_MRK   = 0o2000 // Mark track error
+	DT_STB_END   = 0o1000 // End of tape
+	DT_STB_SEL   = 0o0400 // Select error
+	DT_STB_PAR   = 0o0200 // Parity error
+	DT_STB_TIM   = 0o0100 // Timing error
+	DT_STB_FIELD = 0o0070 // Memory field
+	DT_STB_DTF   = 0o0001 // DECtape flag
+	DT_STB_ERR   = DT_STB_ERF | DT_STB_MRK | DT_STB_END | DT_STB_SEL | DT_STB_PAR | DT_STB_TIM
+)
+
+// Word count and current address of the three cycle data break, in field 0
+const (
+	DT_WC = 0o7754
+	DT_CA = 0o7755
+)
+
+// TU56 tape format, 1474 blocks of 129 words (.tu56 or .dt8)
+const (
+	DT_DRIVES = 8
+	DT_BLOCKS = 1474
+	DT_WORDS  = 129
+)
+
+// TU56 timing
+const (
+	DT_startTime = 150 * time.Millisecond // Getting up to speed, or turning round
+	DT_blockTime = 18 * time.Millisecond  // A block passing the head
+)
+
+// TC08Device is a TC08 DECtape controller with up to eight TU56 transports.
+// Tapes hold 1474 blocks of 129 words and can be searched, read and written
+// in either direction. Data moves by three cycle data break through the word
+// count and current address at 7754 and 7755. In normal mode the DECtape flag
+// is set after every block, in continuous mode when the word count overflows.
+type TC08Device struct {
+	Device
+
+	STA uint16 // Status register A
+	STB uint16 // Status register B
+
+	drives [DT_DRIVES]tu56
+
+	// Set once the word count has overflowed, nothing more is transferred
+	// until status register A is loaded again
+	wco bool
+
+	// Bumped whenever the tape motion changes, so block events for the old
+	// motion are dropped
+	motion int
+
+	mk  *MK12
+	ac  uint16
+	dev uint16
+}
+
+// A TU56 transport
+type tu56 struct {
+	img *diskImage
+
+	// The tape is between blocks pos-1 and pos
+	pos int
+
+	moving  bool
+	reverse bool
+}
+
+func NewTC08() Storage {
+	return &TC08Device{}
+}
+
+func init() {
+	storageControllers["dt"] = NewTC08
+	loaders["dt"] = Loader{
+		Help:   "TC08 bootstrap, reads block 0 of dt0 into 7600 and starts it",
+		Origin: 0o200,
+		Start:  0o200,
+		Words:  dtBoot,
+		SetUnit: func(words []uint16, unit int) {
+			words[14] |= uint16(unit) << 9
+			words[18] |= uint16(unit) << 9
+		},
+	}
+}
+
+// The TC08 bootstrap, as in examples/dectape_boot. It backs the tape into
+// the end zone, reads block 0 into 7600 and jumps there. The block's 129th
+// word wraps round into location 0000.
+var dtBoot = []uint16{
+	0o7600, // 200 BOOT, 7600           / CLA, and where block 0 starts
+	0o1216, // 201       TAD MVB        / Back up into the end zone
+	0o4210, // 202       JMS DO
+	0o1217, // 203       TAD K7577      / Block 0 goes to 7600 onwards
+	0o3620, // 204       DCA I CA
+	0o1222, // 205       TAD RF         / Read it forward
+	0o4210, // 206       JMS DO
+	0o5600, // 207       JMP I BOOT
+	0o0000, // 210 DO,   0
+	0o6766, // 211       DTCA DTXA      / Load status register A and go
+	0o3621, // 212       DCA I WC       / Normal mode, one block
+	0o6771, // 213       DTSF
+	0o5213, // 214       JMP .-1
+	0o5610, // 215       JMP I DO
+	0o0600, // 216 MVB,  0600           / Move, reverse, unit 0
+	0o7577, // 217 K7577, 7577
+	0o7755, // 220 CA,   7755
+	0o7754, // 221 WC,   7754
+	0o0220, // 222 RF,   0220           / Read, forward, unit 0
+}
+
+func (dt *TC08Device) DriveName() string {
+	return "dt"
+}
+
+func (dt *TC08Device) Drives() int {
+	return DT_DRIVES
+}
+
+func (dt *TC08Device) Attach(drive int, path string, readOnly bool) error {
+	img, err := openImage(path, readOnly)
+	if err != nil {
+		return err
+	}
+	dt.drives[drive] = tu56{img: img}
+	return nil
+}
+
+func (dt *TC08Device) Detach(drive int) error {
+	d := &dt.drives[drive]
+	if d.img == nil {
+		return fmt.Errorf("nothing is attached to dt%d", drive)
+	}
+	err := d.img.Close()
+	*d = tu56{}
+	return err
+}
+
+func (dt *TC08Device) Image(drive int) *diskImage {
+	return dt.drives[drive].img
+}
+
+func (dt *TC08Device) Select(addr uint16, mk *MK12) bool {
+	if addr != DT_DEV_A && addr != DT_DEV_B {
+		return false
+	}
+	dt.dev = addr
+	dt.mk = mk
+	dt.ac = mk.AC
+	return true
+}
+
+func (dt *TC08Device) Get() uint16 {
+	if dt.dev == DT_DEV_A {
+		return dt.STA
+	}
+	return dt.STB
+}
+
+func (dt *TC08Device) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	if dt.dev == DT_DEV_B {
+		if fn&DTSF != 0 {
+			skip = dt.STB&(DT_STB_ERF|DT_STB_DTF) != 0
+		}
+		or = fn&DTRB != 0
+		if fn&DTLB != 0 {
+			dt.STB = dt.STB&^DT_STB_FIELD | dt.ac&DT_STB_FIELD
+			clr = true
+		}
+		return
+	}
+
+	or = fn&DTRA != 0
+	if fn&(DTCA|DTXA) == 0 {
+		return
+	}
+	old := dt.STA
+	if fn&DTCA != 0 {
+		dt.STA = 0
+	}
+	if fn&DTXA != 0 {
+		if dt.ac&DT_STA_CERF == 0 {
+			dt.STB &^= DT_STB_ERR
+		}
+		if dt.ac&DT_STA_CDTF == 0 {
+			dt.STB &^= DT_STB_DTF
+		}
+		dt.STA ^= dt.ac & DT_STA_WRITE
+		clr = true
+	}
+	dt.newStatus(old)
+	return
+}
+
+func (dt *TC08Device) unit(sta uint16) *tu56 {
+	return &dt.drives[(sta&DT_STA_UNIT)>>9]
+}
+
+// Starts, stops or turns the tape round after status register A is loaded
+func (dt *TC08Device) newStatus(old uint16) {
+	dt.wco = false
+	d := dt.unit(dt.STA)
+
+	// A tape that is no longer selected coasts to a stop
+	if o := dt.unit(old); o != d {
+		o.moving = false
+	}
+	if dt.STA&DT_STA_GO == 0 {
+		if d.moving {
+			dt.motion++
+			d.moving = false
+		}
+		return
+	}
+	if d.img == nil {
+		dt.error(DT_STB_SEL)
+		return
+	}
+
+	// Changing the function or mode on the fly applies from the next block,
+	// otherwise the tape has to get up to speed first
+	reverse := dt.STA&DT_STA_REV != 0
+	if d.moving && d.reverse == reverse {
+		if old&(DT_STA_UNIT|DT_STA_REV|DT_STA_GO) == dt.STA&(DT_STA_UNIT|DT_STA_REV|DT_STA_GO) {
+			return
+		}
+	}
+	dt.motion++
+	d.moving = true
+	d.reverse = reverse
+	dt.schedule(DT_startTime)
+}
+
+// Schedules the next block to pass the head
+func (dt *TC08Device) schedule(delay time.Duration) {
+	motion := dt.motion
+	dt.mk.schedule(delay, func() {
+		if motion == dt.motion {
+			dt.block()
+		}
+	})
+}
+
+// Sets the error flag and stops the tape
+func (dt *TC08Device) error(bits uint16) {
+	dt.STB |= DT_STB_ERF | bits
+	dt.STA &^= DT_STA_GO
+	dt.unit(dt.STA).moving = false
+	dt.motion++
+}
+
+// Word count and current address cycles of a three cycle data break,
+// returning the address of the next word
+func (dt *TC08Device) dataBreak() uint16 {
+	addr, overflow := dt.mk.threeCycle(DT_WC, (dt.STB&DT_STB_FIELD)>>3, true)
+	if overflow {
+		dt.wco = true
+	}
+	return addr
+}
+
+// A block passes the head, the current function is carried out on it
+func (dt *TC08Device) block() {
+	d := dt.unit(dt.STA)
+	if !d.moving || d.img == nil {
+		return
+	}
+	blk := d.pos
+	if d.reverse {
+		blk--
+	}
+	if blk < 0 || blk >= DT_BLOCKS {
+		dt.error(DT_STB_END)
+		return
+	}
+	continuous := dt.STA&DT_STA_MODE != 0
+	off := int64(blk) * DT_WORDS
+
+	// After the word count overflows blocks just go past, until the program
+	// stops the tape or loads a new function
+	fn := (dt.STA & DT_STA_FUNC) >> 3
+	if dt.wco && fn != DT_SEARCH {
+		fn = DT_MOVE
+	}
+
+	switch fn {
+	case DT_SEARCH:
+		// The block number goes to the current address without advancing it
+		addr, overflow := dt.mk.threeCycle(DT_WC, (dt.STB&DT_STB_FIELD)>>3, false)
+		dt.mk.breakIn(addr, uint16(blk))
+		if !continuous || overflow {
+			dt.STB |= DT_STB_DTF
+		}
+
+	case DT_READ, DT_READALL:
+		buf := make([]uint16, DT_WORDS)
+		if err := d.img.ReadWords(off, buf); err != nil {
+			dt.error(DT_STB_PAR)
+			return
+		}
+		for i := range buf {
+			if dt.wco {
+				break
+			}
+			w := buf[i]
+			if d.reverse {
+				w = obverse(buf[DT_WORDS-1-i])
+			}
+			dt.mk.breakIn(dt.dataBreak(), w)
+		}
+		if !continuous || dt.wco {
+			dt.STB |= DT_STB_DTF
+		}
+
+	case DT_WRITE, DT_WRITEALL:
+		if d.img.ReadOnly {
+			dt.error(DT_STB_SEL)
+			return
+		}
+		// Once the word count overflows the rest of the block is zeros
+		buf := make([]uint16, DT_WORDS)
+		for i := range buf {
+			if dt.wco {
+				break
+			}
+			w := dt.mk.breakOut(dt.dataBreak())
+			if d.reverse {
+				buf[DT_WORDS-1-i] = obverse(w)
+			} else {
+				buf[i] = w
+			}
+		}
+		if err := d.img.WriteWords(off, buf); err != nil {
+			dt.error(DT_STB_PAR)
+			return
+		}
+		if !continuous || dt.wco {
+			dt.STB |= DT_STB_DTF
+		}
+
+	case DT_TIMING:
+		dt.error(DT_STB_MRK)
+		return
+	}
+
+	if d.reverse {
+		d.pos--
+	} else {
+		d.pos++
+	}
+	dt.schedule(DT_blockTime)
+}
+
+// Words read or written backwards come out as their obverse complement: the
+// octal digits reversed and complemented
+func obverse(w uint16) uint16 {
+	return ^(w&0o7<<9 | w&0o70<<3 | w&0o700>>3 | w&0o7000>>9) & 0o7777
+}
+
+func (dt *TC08Device) InterruptRequest() bool {
+	return dt.STA&DT_STA_ENB != 0 && dt.STB&(DT_STB_ERF|DT_STB_DTF) != 0
+}
+
+// CAF clears both status registers, stopping the tape
+func (dt *TC08Device) ClearFlags() {
+	dt.unit(dt.STA).moving = false
+	dt.STA, dt.STB = 0, 0
+	dt.wco = false
+	dt.motion++
+}
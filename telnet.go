@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options (RFC 854, 857, 858)
+const (
+	TN_SE   = 240 // End of subnegotiation
+	TN_NOP  = 241
+	TN_SB   = 250 // Start of subnegotiation
+	TN_WILL = 251
+	TN_WONT = 252
+	TN_DO   = 253
+	TN_DONT = 254
+	TN_IAC  = 255 // Interpret as command
+
+	TN_ECHO = 1 // Echo option
+	TN_SGA  = 3 // Suppress go ahead option
+)
+
+// How long a terminal may take to accept output before it is dropped
+const TN_timeout = 5 * time.Second
+
+// TelnetTerminal is a teletype keyboard and printer served over telnet. Any
+// number of terminals can connect: everything printed goes to all of them
+// and what any of them types goes to the keyboard. With nothing connected the
+// printer is not ready, so programs wait for a terminal before printing. A
+// terminal that stops reading holds the printer up for TN_timeout at most.
+type TelnetTerminal struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	conns map[net.Conn]*bufio.Writer
+
+	// Characters typed but not read yet
+	in []byte
+}
+
+// Starts listening for telnet connections on addr (host:port)
+func NewTelnetTerminal(addr string) (*TelnetTerminal, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	t := &TelnetTerminal{
+		listener: ln,
+		conns:    make(map[net.Conn]*bufio.Writer),
+	}
+	go t.accept()
+	fmt.Fprintf(os.Stderr, "Teletype on telnet %s\n", ln.Addr())
+	return t, nil
+}
+
+func (t *TelnetTerminal) accept() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+		go t.serve(conn)
+	}
+}
+
+// Talks to one terminal until it disconnects
+func (t *TelnetTerminal) serve(conn net.Conn) {
+	defer conn.Close()
+	w := bufio.NewWriter(conn)
+
+	// Character at a time mode: we echo (the program does) and there are no
+	// go aheads, so the client sends each key as it is pressed
+	w.Write([]byte{
+		TN_IAC, TN_WILL, TN_ECHO,
+		TN_IAC, TN_WILL, TN_SGA,
+		TN_IAC, TN_DO, TN_SGA,
+	})
+	w.WriteString("Connected to the mksim teletype\r\n")
+	conn.SetWriteDeadline(time.Now().Add(TN_timeout))
+	if w.Flush() != nil {
+		return
+	}
+
+	t.mu.Lock()
+	t.conns[conn] = w
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.conns, conn)
+		t.mu.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	lastCR := false
+	for {
+		c, err := r.ReadByte()
+		if err != nil {
+			return
+		}
+		if c == TN_IAC {
+			if c, err = t.command(r, conn, w); err != nil {
+				return
+			}
+			if c != TN_IAC {
+				continue
+			}
+		}
+
+		// Enter arrives as CR LF or CR NUL, or just LF from a raw TCP client,
+		// the teletype only sends CR
+		if lastCR && (c == '\n' || c == 0) {
+			lastCR = false
+			continue
+		}
+		if c == '\n' {
+			c = '\r'
+		}
+		lastCR = c == '\r'
+
+		t.mu.Lock()
+		t.in = append(t.in, c)
+		t.mu.Unlock()
+	}
+}
+
+// Handles a telnet command following IAC. Options other than echo and
+// suppress go ahead are refused. Returns IAC for an escaped 0377 data byte.
+func (t *TelnetTerminal) command(r *bufio.Reader, conn net.Conn, w *bufio.Writer) (byte, error) {
+	cmd, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch cmd {
+	case TN_IAC:
+		return TN_IAC, nil
+	case TN_DO, TN_DONT, TN_WILL, TN_WONT:
+		opt, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		var reply byte
+		switch {
+		case cmd == TN_DO && opt != TN_ECHO && opt != TN_SGA:
+			reply = TN_WONT
+		case cmd == TN_WILL && opt != TN_SGA:
+			reply = TN_DONT
+		}
+		if reply != 0 {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			w.Write([]byte{TN_IAC, reply, opt})
+			conn.SetWriteDeadline(time.Now().Add(TN_timeout))
+			return 0, w.Flush()
+		}
+	case TN_SB:
+		// Skip subnegotiations up to IAC SE
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return 0, err
+			}
+			if c == TN_IAC {
+				if c, err = r.ReadByte(); err != nil || c == TN_SE {
+					return 0, err
+				}
+			}
+		}
+	}
+	return 0, nil
+}
+
+func (t *TelnetTerminal) Buffered() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.in)
+}
+
+func (t *TelnetTerminal) ReadByte() (byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.in) == 0 {
+		return 0, nil
+	}
+	c := t.in[0]
+	t.in = t.in[1:]
+	return c, nil
+}
+
+// Prints a character on every connected terminal. Every CR is sent as
+// CR NUL, the telnet carriage return, so the CR LF a program prints is a
+// carriage return and a line feed as it is on the teletype.
+func (t *TelnetTerminal) WriteByte(c byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c &= 0o177
+	for _, w := range t.conns {
+		w.WriteByte(c)
+		if c == '\r' {
+			w.WriteByte(0)
+		}
+	}
+	return nil
+}
+
+func (t *TelnetTerminal) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for conn, w := range t.conns {
+		conn.SetWriteDeadline(time.Now().Add(TN_timeout))
+		if w.Flush() != nil {
+			// Its serve goroutine sees the close and forgets it
+			conn.Close()
+		}
+	}
+	return nil
+}
+
+// The printer is only ready while a terminal is connected
+func (t *TelnetTerminal) Available() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.conns)
+}
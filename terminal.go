@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// FileTerminal is a serial line connected to files: what the program prints
+// is written to one and what it reads comes from the other.
+type FileTerminal struct {
+	in  *bufio.Reader
+	out *bufio.Writer
+
+	// The files, nil for those not given
+	inf  *os.File
+	outf *os.File
+}
+
+// Opens in for reading and creates out for writing. Without an out file
+// everything printed is thrown away.
+func NewFileTerminal(in string, out string) (*FileTerminal, error) {
+	t := &FileTerminal{out: bufio.NewWriter(io.Discard)}
+	if in != "" {
+		f, err := os.Open(in)
+		if err != nil {
+			return nil, err
+		}
+		t.inf = f
+		t.in = bufio.NewReader(f)
+	} else {
+		t.in = bufio.NewReader(bytes.NewReader(nil))
+	}
+	if out != "" {
+		f, err := os.Create(out)
+		if err != nil {
+			t.Close()
+			return nil, err
+		}
+		t.outf = f
+		t.out = bufio.NewWriter(f)
+	}
+	return t, nil
+}
+
+func (t *FileTerminal) Buffered() int {
+	if _, err := t.in.Peek(1); err != nil {
+		return 0
+	}
+	return t.in.Buffered()
+}
+
+func (t *FileTerminal) ReadByte() (byte, error) {
+	return t.in.ReadByte()
+}
+
+func (t *FileTerminal) WriteByte(c byte) error {
+	return t.out.WriteByte(c)
+}
+
+func (t *FileTerminal) Flush() error {
+	return t.out.Flush()
+}
+
+func (t *FileTerminal) Available() int {
+	return t.out.Available()
+}
+
+// Writes out what is left to print and closes the files
+func (t *FileTerminal) Close() error {
+	err := t.out.Flush()
+	if t.inf != nil {
+		t.inf.Close()
+	}
+	if t.outf != nil {
+		if cerr := t.outf.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// A serial line given on the command line as code=backend
+type lineSpec struct {
+	Code    uint16 // Keyboard device code, the printer is at Code+1
+	Backend string
+}
+
+// Parses a -kl8e line: an octal keyboard device code and what the line is
+// connected to. The printer is at the next device code.
+func parseLineSpec(spec string) (l lineSpec, err error) {
+	code, backend, ok := strings.Cut(spec, "=")
+	if !ok || backend == "" {
+		return l, fmt.Errorf("serial line %q is not code=backend", spec)
+	}
+	n, err := strconv.ParseUint(code, 8, 6)
+	if err != nil || n < 0o01 || n > 0o76 {
+		return l, fmt.Errorf("serial line device code %q is not octal 01-76", code)
+	}
+	if n == CPU_IOT || (n+1 >= MEM_IOT_begin && n <= MEM_IOT_end) {
+		return l, fmt.Errorf("serial line device codes %02o/%02o are used by the processor", n, n+1)
+	}
+	for _, c := range []uint16{uint16(n), uint16(n) + 1} {
+		if name, ok := builtinDeviceCodes[c]; ok {
+			return l, fmt.Errorf("serial line device code %02o is used by %s", c, name)
+		}
+	}
+	return lineSpec{Code: uint16(n), Backend: backend}, nil
+}
+
+// Creates the keyboard and printer for a serial line backend:
+//
+//	stdin              the terminal the simulator runs in
+//	file:in[,out]      read from in and write to out
+//	tcp:host:port      telnet server
+//	pty                pseudo-terminal, the slave path is printed on startup
+func openLine(backend string) (TeleTypeKeyboard, TeleTypePrinter, error) {
+	kind, arg, _ := strings.Cut(backend, ":")
+	switch kind {
+	case "stdin":
+		return NewStdinKeyboard(), bufio.NewWriter(os.Stdout), nil
+	case "file":
+		in, out, _ := strings.Cut(arg, ",")
+		t, err := NewFileTerminal(in, out)
+		if err != nil {
+			return nil, nil, err
+		}
+		return t, t, nil
+	case "tcp":
+		t, err := NewTelnetTerminal(arg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return t, t, nil
+	case "pty":
+		return openPtyLine()
+	}
+	return nil, nil, fmt.Errorf("unknown serial line backend %q", backend)
+}
+
+// Adds the serial lines given with -kl8e to the machine. A line at 03 takes
+// the place of the console teletype.
+func (mk *MK12) attachLines(specs []string) error {
+	used := map[uint16]bool{TT_KEYBOARD: true, TT_PRINTER: true}
+	for _, s := range specs {
+		l, err := parseLineSpec(s)
+		if err != nil {
+			return err
+		}
+		console := l.Code == TT_KEYBOARD
+		if !console && (used[l.Code] || used[l.Code+1]) {
+			return fmt.Errorf("serial line device codes %02o/%02o are already in use", l.Code, l.Code+1)
+		}
+		used[l.Code], used[l.Code+1] = true, true
+
+		kb, p, err := openLine(l.Backend)
+		if err != nil {
+			return err
+		}
+		if console {
+			for _, dev := range mk.IOT {
+				if tt, ok := dev.(*TeleTypeDevice); ok && tt.KeyboardCode == TT_KEYBOARD {
+					tt.Keyboard, tt.Printer = kb, p
+				}
+			}
+			continue
+		}
+		mk.IOT = append(mk.IOT, NewTeleTypeDevice(l.Code, kb, p))
+	}
+	return nil
+}
+
+// Closes the serial lines that need it before the simulator exits
+func (mk *MK12) closeLines() {
+	for _, dev := range mk.IOT {
+		if tt, ok := dev.(*TeleTypeDevice); ok {
+			if c, ok := tt.Printer.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseLineSpec(t *testing.T) {
+	for spec, ok := range map[string]bool{
+		"40=stdin": true,
+		"03=pty":   true,
+		"01=stdin": false, // Paper tape reader
+		"12=stdin": false, // Clock at 13
+		"66=stdin": false, // Line printer
+		"73=stdin": false, // RK8-E at 74
+		"76=stdin": false, // TC08
+		"00=stdin": false,
+		"20=stdin": false, // Memory extension
+		"40":       false,
+	} {
+		if _, err := parseLineSpec(spec); (err == nil) != ok {
+			t.Errorf("%s: %v", spec, err)
+		}
+	}
+}
+
+// Closing a line's files writes out what is left to print
+func TestFileTerminalClose(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	ft, err := NewFileTerminal("", out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft.WriteByte('A')
+	if err := ft.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(out); string(b) != "A" {
+		t.Errorf("wrote %q", b)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strconv"
 )
 
@@ -66,6 +67,18 @@ func MKrotateLeft(a uint16, l bool) (x uint16, y bool) {
 	return
 }
 
+// Load an object file, basing the format off the extension
+func LoadObjectFile(filename string) (mem [4096]uint16, err error) {
+	switch path.Ext(filename) {
+	case ".rim":
+		return LoadRIMFile(filename)
+	case ".po":
+		fallthrough
+	default:
+		return LoadPObjFile(filename)
+	}
+}
+
 // Load an object file produced by pdpnasm.
 // This function returns an array of 4096 int16's representing pdp8 memory
 func LoadPObjFile(filename string) (mem [4096]uint16, err error) {
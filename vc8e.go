@@ -0,0 +1,324 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// VC8-E point plot display control device code
+const VC_DEV = 0o05
+
+// VC8-E function codes
+const (
+	DILC = 0o0 // Clear the enable register and the done flag
+	DICD = 0o1 // Clear the done flag
+	DISD = 0o2 // Skip on done flag
+	DILX = 0o3 // Load the X register from AC
+	DILY = 0o4 // Load the Y register from AC
+	DIXY = 0o5 // Intensify the point at X, Y
+	DILE = 0o6 // Load the enable register from AC
+	DIRE = 0o7 // Read the enable register and the done flag into AC
+)
+
+// Enable register
+const (
+	VC_ENB_DONE = 0o4000 // Done flag, read only
+	VC_ENB_CH2  = 0o0002 // Intensify on channel 2 instead of 1
+	VC_ENB_IE   = 0o0001 // Interrupt on done
+	VC_ENB_LOAD = 0o0003 // Bits loaded by DILE
+)
+
+// The screen is 1024 points square, X and Y are ten bit two's complement
+// numbers with 0, 0 in the middle
+const VC_SIZE = 1024
+
+// How long the deflection takes to settle after a register is loaded, and a
+// point takes to intensify
+const (
+	VC_settleTime    = 3 * time.Microsecond
+	VC_intensifyTime = 2 * time.Microsecond
+)
+
+// VC8Device is a VC8-E driving two point plot displays. Points fade out as
+// the phosphor decays, with the brightness falling to 1/e in Decay. The
+// phosphor only keeps the time each point was last intensified and is
+// rendered from that, to PNG images or braille characters.
+type VC8Device struct {
+	Device
+
+	X    uint16
+	Y    uint16
+	ENB  uint16 // Enable register
+	Done bool
+
+	// Time constant of the phosphor
+	Decay time.Duration
+
+	// Simulated time each point of each channel was last intensified, plus
+	// one so zero is never
+	phosphor [2][]time.Duration
+	used     [2]bool
+
+	mk  *MK12
+	ac  uint16
+	gen int // Bumped to drop the done flag of an earlier operation
+
+	// Frames being encoded by RecordFrames, and the first that failed
+	frames    sync.WaitGroup
+	frameMu   sync.Mutex
+	frameErr  error
+	frameErrs int
+}
+
+func NewVC8(mk *MK12, decay time.Duration) *VC8Device {
+	vc := &VC8Device{Decay: decay, mk: mk}
+	vc.phosphor[0] = make([]time.Duration, VC_SIZE*VC_SIZE)
+	vc.phosphor[1] = make([]time.Duration, VC_SIZE*VC_SIZE)
+	return vc
+}
+
+func (vc *VC8Device) Select(addr uint16, mk *MK12) bool {
+	if addr != VC_DEV {
+		return false
+	}
+	vc.ac = mk.AC
+	return true
+}
+
+func (vc *VC8Device) Get() uint16 {
+	if vc.Done {
+		return vc.ENB | VC_ENB_DONE
+	}
+	return vc.ENB
+}
+
+func (vc *VC8Device) Operate(fn uint16) (skip bool, clr bool, or bool) {
+	switch fn {
+	case DILC:
+		vc.ClearFlags()
+	case DICD:
+		vc.Done = false
+	case DISD:
+		skip = vc.Done
+	case DILX:
+		vc.X = vc.ac & 0o1777
+		vc.done(VC_settleTime)
+		clr = true
+	case DILY:
+		vc.Y = vc.ac & 0o1777
+		vc.done(VC_settleTime)
+		clr = true
+	case DIXY:
+		vc.intensify()
+		vc.done(VC_intensifyTime)
+	case DILE:
+		vc.ENB = vc.ac & VC_ENB_LOAD
+		clr = true
+	case DIRE:
+		clr, or = true, true
+	}
+	return
+}
+
+// Clears the done flag and sets it again after delay
+func (vc *VC8Device) done(delay time.Duration) {
+	vc.Done = false
+	vc.gen++
+	gen := vc.gen
+	vc.mk.schedule(delay, func() {
+		if gen == vc.gen {
+			vc.Done = true
+		}
+	})
+}
+
+// Returns the screen position of a ten bit coordinate, from the left or
+// bottom edge
+func vcPosition(c uint16) int {
+	return int(c^0o1000) & (VC_SIZE - 1)
+}
+
+func (vc *VC8Device) intensify() {
+	ch := 0
+	if vc.ENB&VC_ENB_CH2 != 0 {
+		ch = 1
+	}
+	x, y := vcPosition(vc.X), vcPosition(vc.Y)
+	vc.phosphor[ch][(VC_SIZE-1-y)*VC_SIZE+x] = vc.mk.HW.TIME + 1
+	vc.used[ch] = true
+}
+
+// Brightness from 0 to 1 of a point last intensified at lit, at time now
+func (vc *VC8Device) brightness(lit time.Duration, now time.Duration) float64 {
+	if lit == 0 {
+		return 0
+	}
+	if vc.Decay <= 0 {
+		return 1
+	}
+	return math.Exp(-float64(now-lit+1) / float64(vc.Decay))
+}
+
+func (vc *VC8Device) InterruptRequest() bool {
+	return vc.ENB&VC_ENB_IE != 0 && vc.Done
+}
+
+// CAF clears the enable register and the done flag
+func (vc *VC8Device) ClearFlags() {
+	vc.ENB = 0
+	vc.Done = false
+	vc.gen++
+}
+
+// Renders a channel (0 or 1) as it looks now, in the green of the phosphor
+func (vc *VC8Device) Image(ch int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, VC_SIZE, VC_SIZE))
+	now := vc.mk.HW.TIME
+	for i, lit := range vc.phosphor[ch] {
+		b := vc.brightness(lit, now)
+		img.Pix[i*4+0] = uint8(64 * b)
+		img.Pix[i*4+1] = uint8(255 * b)
+		img.Pix[i*4+2] = uint8(64 * b)
+		img.Pix[i*4+3] = 255
+	}
+	return img
+}
+
+// Renders a channel as rows of cols braille characters, each showing two by
+// four cells of the screen. A dot is shown if any point in its cell is at
+// least a quarter as bright as a freshly intensified one.
+func (vc *VC8Device) Braille(ch int, cols int, rows int) []string {
+	dots := make([]uint8, cols*rows)
+	now := vc.mk.HW.TIME
+	for i, lit := range vc.phosphor[ch] {
+		if lit == 0 || vc.brightness(lit, now) < 0.25 {
+			continue
+		}
+		dx := i % VC_SIZE * cols * 2 / VC_SIZE
+		dy := i / VC_SIZE * rows * 4 / VC_SIZE
+		dots[dy/4*cols+dx/2] |= brailleDots[dy%4][dx%2]
+	}
+	lines := make([]string, rows)
+	for r := range lines {
+		line := make([]rune, cols)
+		for c := range line {
+			line[c] = 0x2800 + rune(dots[r*cols+c])
+		}
+		lines[r] = string(line)
+	}
+	return lines
+}
+
+// Braille pattern bits by dot row and column
+var brailleDots = [4][2]uint8{{0x01, 0x08}, {0x02, 0x10}, {0x04, 0x20}, {0x40, 0x80}}
+
+// Writes a channel to a PNG file
+func (vc *VC8Device) WritePNG(path string, ch int) error {
+	return writePNG(path, vc.Image(ch))
+}
+
+func writePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Writes a frame of each channel in use to dir every 1/fps of simulated
+// time, as frame-00001.png and so on for channel 1 and frame2-00001.png for
+// channel 2. Frames are encoded in the background, Close waits for them.
+func (vc *VC8Device) RecordFrames(dir string, fps int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	n := 0
+	var frame func()
+	frame = func() {
+		n++
+		for ch, prefix := range []string{"frame", "frame2"} {
+			if !vc.used[ch] {
+				continue
+			}
+			img := vc.Image(ch)
+			path := filepath.Join(dir, fmt.Sprintf("%s-%05d.png", prefix, n))
+			vc.frames.Add(1)
+			go func() {
+				defer vc.frames.Done()
+				if err := writePNG(path, img); err != nil {
+					vc.frameMu.Lock()
+					if vc.frameErr == nil {
+						vc.frameErr = err
+					}
+					vc.frameErrs++
+					vc.frameMu.Unlock()
+				}
+			}()
+		}
+		vc.mk.schedule(time.Second/time.Duration(fps), frame)
+	}
+	vc.mk.schedule(time.Second/time.Duration(fps), frame)
+	return nil
+}
+
+// Waits for the frames being written and returns the first error writing
+// one, if any
+func (vc *VC8Device) Close() error {
+	vc.frames.Wait()
+	if vc.frameErrs > 1 {
+		return fmt.Errorf("%d frames weren't written, the first: %v", vc.frameErrs, vc.frameErr)
+	}
+	return vc.frameErr
+}
+
+// Returns the VC8-E, nil if there isn't one
+func (mk *MK12) vc8() *VC8Device {
+	for _, dev := range mk.IOT {
+		if vc, ok := dev.(*VC8Device); ok {
+			return vc
+		}
+	}
+	return nil
+}
+
+func init() {
+	debugCommands["snapshot"] = DebugCommand{
+		Usage: "<path> [channel]",
+		Help:  "Write what the VC8-E display shows to a PNG file, channel 1 or 2",
+		Run:   cmdSnapshot,
+		Files: true,
+	}
+}
+
+func cmdSnapshot(mk *MK12, args []string) (string, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return "", fmt.Errorf("usage: snapshot <path> [channel]")
+	}
+	vc := mk.vc8()
+	if vc == nil {
+		return "", fmt.Errorf("no VC8-E display, use -vc8")
+	}
+	ch := 1
+	if len(args) == 2 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 || n > 2 {
+			return "", fmt.Errorf("channel must be 1 or 2")
+		}
+		ch = n
+	}
+	if err := vc.WritePNG(args[0], ch-1); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("channel %d written to %s", ch, args[0]), nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed webpanel.html
+var webPanelHTML string
+
+// How often machine state is pushed to the browsers
+const WEB_refresh = 50 * time.Millisecond
+
+// Teletype output kept for browsers that connect later
+const WEB_scrollback = 16 * 1024
+
+// Front panel keys by the names the web page uses
+var webKeys = map[string]PanelKey{
+	"cont":  KEY_CONT,
+	"halt":  KEY_HALT,
+	"inst":  KEY_INST,
+	"step":  KEY_STEP,
+	"load":  KEY_LOAD,
+	"dep":   KEY_DEP,
+	"exam":  KEY_EXAM,
+	"clear": KEY_CLEAR,
+}
+
+// WebFrontPanel serves the front panel as a web page. Machine state and
+// teletype output are pushed to every connected browser over a WebSocket,
+// and keys, switches and typing come back the same way, so any number of
+// people can watch and operate the machine.
+type WebFrontPanel struct {
+	// Address to listen on, as host:port
+	Addr string
+
+	// Whether browsers may run debug commands that touch files on the host
+	Files bool
+
+	listener net.Listener
+
+	// Everything below is shared between the CPU, the HTTP handlers and the
+	// refresh loop
+	mu sync.Mutex
+
+	// Latest state from Update and whether it has been sent yet
+	state   webState
+	changed bool
+
+	// Words of memory installed, and copies of the pages the browsers show
+	// taken in Update, by 15-bit address of the first word
+	size  int
+	pages map[uint16][]uint16
+
+	// Switch register and field switches
+	sr, ifs, dfs uint16
+
+	// Teletype output not sent yet, and the recent output for new browsers
+	ttyOut     []byte
+	scrollback []byte
+
+	// Teletype keyboard input typed in a browser, not yet read
+	ttyIn []byte
+
+	clients map[*webClient]bool
+
+	keys     chan PanelKey
+	commands chan string
+}
+
+// A browser connected to the panel
+type webClient struct {
+	conn *wsConn
+	send chan []byte
+
+	// 15-bit address of the memory page shown, -1 to follow the PC
+	page int
+}
+
+// Machine state sent to the browsers
+type webState struct {
+	Type         string   `json:"type"`
+	Status       string   `json:"status"`
+	AC           uint16   `json:"ac"`
+	L            bool     `json:"l"`
+	MQ           uint16   `json:"mq"`
+	PC           uint16   `json:"pc"`
+	IR           uint16   `json:"ir"`
+	MA           uint16   `json:"ma"`
+	MB           uint16   `json:"mb"`
+	CPMA         uint16   `json:"cpma"`
+	IF           uint16   `json:"if"`
+	DF           uint16   `json:"df"`
+	IE           bool     `json:"ie"`
+	SR           uint16   `json:"sr"`
+	IFS          uint16   `json:"ifs"`
+	DFS          uint16   `json:"dfs"`
+	Model        string   `json:"model"`
+	Instructions uint64   `json:"instructions"`
+	Page         uint16   `json:"page"`
+	Follow       bool     `json:"follow"`
+	Mem          []uint16 `json:"mem"`
+}
+
+// Text sent to the browsers, teletype output or debug console output
+type webText struct {
+	Type string `json:"type"`
+	Data string `json:"data"`
+}
+
+// Events sent by the browsers
+type webEvent struct {
+	Type  string `json:"type"` // key, sr, fields, tty, page or command
+	Key   string `json:"key"`
+	Value int    `json:"value"`
+	IF    uint16 `json:"if"`
+	DF    uint16 `json:"df"`
+	Data  string `json:"data"`
+}
+
+func (fp *WebFrontPanel) PowerOn(mk MK12) {
+	fp.clients = make(map[*webClient]bool)
+	fp.pages = make(map[uint16][]uint16)
+	fp.keys = make(chan PanelKey, 16)
+	fp.commands = make(chan string, 16)
+
+	ln, err := net.Listen("tcp", fp.Addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ERROR: %v\n", err)
+		os.Exit(1)
+	}
+	fp.listener = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", fp.serveHTML)
+	mux.HandleFunc("/ws", fp.serveWebSocket)
+	go http.Serve(ln, mux)
+	go fp.refresh()
+
+	fmt.Fprintf(os.Stderr, "Front panel on http://%s/\n", ln.Addr())
+	fp.Update(mk)
+}
+
+func (fp *WebFrontPanel) PowerOff() {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	fp.listener.Close()
+	for c := range fp.clients {
+		c.conn.WriteMessage(WS_CLOSE, nil)
+		c.conn.Close()
+	}
+}
+
+func (fp *WebFrontPanel) Update(mk MK12) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+
+	s := &fp.state
+	switch {
+	case mk.STATE.HALT:
+		s.Status = "HALT"
+	case mk.STATE.SSTEP:
+		s.Status = "STEP"
+	default:
+		s.Status = "RUN"
+	}
+	s.AC, s.L, s.MQ = mk.AC, mk.L, mk.MQ
+	s.PC, s.IR, s.MA, s.MB = mk.IF<<12|mk.PC, mk.IR, mk.MA, mk.MB
+	s.CPMA = mk.mf<<12 | mk.MA
+	s.IF, s.DF, s.IE = mk.IF, mk.DF, mk.STATE.IE
+	s.SR = mk.SR
+	s.Model = mk.HW.MODEL.Description
+	s.Instructions = mk.HW.INSTRUCTIONS
+	fp.copyPages(mk.MEM)
+	fp.changed = true
+}
+
+// Copies the pages the browsers show out of mem, which the CPU goes on
+// changing after Update. Called with mu held.
+func (fp *WebFrontPanel) copyPages(mem []uint16) {
+	fp.size = len(mem)
+	if fp.size == 0 {
+		return
+	}
+	keep := func(page uint16) {
+		words := fp.pages[page]
+		if words == nil {
+			words = make([]uint16, 0o200)
+			fp.pages[page] = words
+		}
+		copy(words, mem[page:])
+	}
+	keep(fp.pageOf(-1))
+	for c := range fp.clients {
+		keep(fp.pageOf(c.page))
+	}
+	for page := range fp.pages {
+		shown := page == fp.pageOf(-1)
+		for c := range fp.clients {
+			shown = shown || page == fp.pageOf(c.page)
+		}
+		if !shown {
+			delete(fp.pages, page)
+		}
+	}
+}
+
+// Returns the page a browser shows, the page of the PC if page is -1.
+// Called with mu held.
+func (fp *WebFrontPanel) pageOf(page int) uint16 {
+	if page < 0 {
+		page = int(fp.state.PC)
+	}
+	return uint16(page%fp.size) &^ 0o177
+}
+
+func (fp *WebFrontPanel) ReadSwitches() uint16 {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.sr
+}
+
+func (fp *WebFrontPanel) ReadFieldSwitches() (uint16, uint16) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	return fp.ifs, fp.dfs
+}
+
+func (fp *WebFrontPanel) ReadKey() PanelKey {
+	select {
+	case key := <-fp.keys:
+		return key
+	default:
+		return KEY_NONE
+	}
+}
+
+func (fp *WebFrontPanel) ReadCommand() string {
+	select {
+	case line := <-fp.commands:
+		return line
+	default:
+		return ""
+	}
+}
+
+func (fp *WebFrontPanel) Print(msg string) {
+	fp.broadcast(webText{Type: "print", Data: msg})
+}
+
+func (fp *WebFrontPanel) serveHTML(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, webPanelHTML)
+}
+
+func (fp *WebFrontPanel) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	c := &webClient{conn: conn, send: make(chan []byte, 64), page: -1}
+
+	fp.mu.Lock()
+	fp.clients[c] = true
+	if len(fp.scrollback) > 0 {
+		c.queue(webText{Type: "tty", Data: string(fp.scrollback)})
+	}
+	c.queue(fp.stateFor(c))
+	fp.mu.Unlock()
+
+	go c.writer()
+	for {
+		msg, err := conn.ReadMessage()
+		if err != nil {
+			break
+		}
+		var ev webEvent
+		if json.Unmarshal(msg, &ev) == nil {
+			fp.handle(c, ev)
+		}
+	}
+
+	fp.mu.Lock()
+	delete(fp.clients, c)
+	close(c.send)
+	fp.mu.Unlock()
+	conn.Close()
+}
+
+// Acts on an event from a browser
+func (fp *WebFrontPanel) handle(c *webClient, ev webEvent) {
+	switch ev.Type {
+	case "key":
+		if key, ok := webKeys[ev.Key]; ok {
+			select {
+			case fp.keys <- key:
+			default:
+			}
+		}
+	case "sr":
+		fp.mu.Lock()
+		fp.sr = uint16(ev.Value) & 0o7777
+		fp.changed = true
+		fp.mu.Unlock()
+	case "fields":
+		fp.mu.Lock()
+		fp.ifs, fp.dfs = ev.IF&0o7, ev.DF&0o7
+		fp.changed = true
+		fp.mu.Unlock()
+	case "tty":
+		fp.mu.Lock()
+		fp.ttyIn = append(fp.ttyIn, ev.Data...)
+		fp.mu.Unlock()
+	case "page":
+		fp.mu.Lock()
+		c.page = ev.Value
+		copied := fp.size == 0
+		if !copied {
+			_, copied = fp.pages[fp.pageOf(c.page)]
+		}
+		if copied {
+			c.queue(fp.stateFor(c))
+		}
+		fp.mu.Unlock()
+		if !copied {
+			// Nothing else updates the panel while halted
+			select {
+			case fp.commands <- refreshCommand:
+			default:
+			}
+		}
+	case "command":
+		fp.broadcast(webText{Type: "print", Data: "> " + ev.Data})
+		words := strings.Fields(ev.Data)
+		if len(words) > 0 && !fp.Files {
+			if cmd, ok := lookupCommand(words[0]); ok && cmd.Files {
+				fp.broadcast(webText{Type: "print", Data: "ERROR: " + words[0] + " needs -web-files"})
+				return
+			}
+		}
+		select {
+		case fp.commands <- ev.Data:
+		default:
+		}
+	}
+}
+
+// Builds the state message for a browser, with the page of memory it shows.
+// Called with mu held.
+func (fp *WebFrontPanel) stateFor(c *webClient) webState {
+	s := fp.state
+	s.Type = "state"
+	s.IFS, s.DFS = fp.ifs, fp.dfs
+	s.SR = fp.sr
+	if fp.size == 0 {
+		return s
+	}
+	s.Follow = c.page < 0
+	s.Page = fp.pageOf(c.page)
+	s.Mem = fp.pages[s.Page]
+	return s
+}
+
+// Pushes changed state and teletype output to the browsers
+func (fp *WebFrontPanel) refresh() {
+	for range time.Tick(WEB_refresh) {
+		fp.mu.Lock()
+		if len(fp.ttyOut) > 0 {
+			for c := range fp.clients {
+				c.queue(webText{Type: "tty", Data: string(fp.ttyOut)})
+			}
+			fp.ttyOut = fp.ttyOut[:0]
+		}
+		if fp.changed {
+			for c := range fp.clients {
+				c.queue(fp.stateFor(c))
+			}
+			fp.changed = false
+		}
+		fp.mu.Unlock()
+	}
+}
+
+func (fp *WebFrontPanel) broadcast(msg interface{}) {
+	fp.mu.Lock()
+	defer fp.mu.Unlock()
+	for c := range fp.clients {
+		c.queue(msg)
+	}
+}
+
+// Queues a message for the browser, dropping it if the browser has fallen
+// behind. Called with mu held.
+func (c *webClient) queue(msg interface{}) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	select {
+	case c.send <- data:
+	default:
+	}
+}
+
+func (c *webClient) writer() {
+	for data := range c.send {
+		if err := c.conn.WriteMessage(WS_TEXT, data); err != nil {
+			c.conn.Close()
+			for range c.send {
+			}
+			return
+		}
+	}
+}
+
+// Teletype keyboard fed by the browsers
+type WebKeyboard struct {
+	fp *WebFrontPanel
+}
+
+func (kb *WebKeyboard) Buffered() int {
+	kb.fp.mu.Lock()
+	defer kb.fp.mu.Unlock()
+	return len(kb.fp.ttyIn)
+}
+
+func (kb *WebKeyboard) ReadByte() (byte, error) {
+	kb.fp.mu.Lock()
+	defer kb.fp.mu.Unlock()
+	if len(kb.fp.ttyIn) == 0 {
+		return 0, nil
+	}
+	c := kb.fp.ttyIn[0]
+	kb.fp.ttyIn = kb.fp.ttyIn[1:]
+	return c, nil
+}
+
+// Teletype printer shown in the browsers
+type WebTeleprinter struct {
+	fp *WebFrontPanel
+}
+
+func (p *WebTeleprinter) WriteByte(c byte) error {
+	p.fp.mu.Lock()
+	defer p.fp.mu.Unlock()
+	c &= 0o177
+	p.fp.ttyOut = append(p.fp.ttyOut, c)
+	p.fp.scrollback = append(p.fp.scrollback, c)
+	if n := len(p.fp.scrollback); n > WEB_scrollback {
+		p.fp.scrollback = p.fp.scrollback[n-WEB_scrollback:]
+	}
+	return nil
+}
+
+func (p *WebTeleprinter) Flush() error {
+	return nil
+}
+
+func (p *WebTeleprinter) Available() int {
+	return 1
+}
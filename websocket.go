@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Just enough of a server side WebSocket (RFC 6455) for the web front panel:
+// the handshake, text and binary messages, ping/pong and close. Messages are
+// sent as a single frame.
+
+// WebSocket opcodes
+const (
+	WS_CONTINUATION = 0x0
+	WS_TEXT         = 0x1
+	WS_BINARY       = 0x2
+	WS_CLOSE        = 0x8
+	WS_PING         = 0x9
+	WS_PONG         = 0xa
+)
+
+// Appended to the client's key to make the accept key
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Largest message accepted from a client
+const wsMaxMessage = 64 * 1024
+
+type wsConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	// Serializes writers, frames from different goroutines must not interleave
+	wmu sync.Mutex
+}
+
+// Completes the WebSocket handshake and takes over the connection
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket request")
+	}
+	// Browsers send the page's origin, so another site's page can't drive
+	// the panel from a browser that can reach it
+	if origin := r.Header.Get("Origin"); origin != "" {
+		if u, err := url.Parse(origin); err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross-origin WebSocket refused", http.StatusForbidden)
+			return nil, fmt.Errorf("WebSocket from origin %q refused", origin)
+		}
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "can't upgrade this connection", http.StatusInternalServerError)
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + wsGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, rw: rw}, nil
+}
+
+// Reads one frame, unmasking the payload
+func (c *wsConn) readFrame() (fin bool, op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.rw, h[:]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	op = h[0] & 0x0f
+	masked := h[1]&0x80 != 0
+
+	n := uint64(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.rw, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.rw, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > wsMaxMessage {
+		err = fmt.Errorf("WebSocket frame of %d bytes is too large", n)
+		return
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.rw, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(c.rw, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return
+}
+
+// Reads the next text or binary message, answering pings along the way.
+// Returns io.EOF once the client has closed the connection.
+func (c *wsConn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case WS_CLOSE:
+			c.WriteMessage(WS_CLOSE, nil)
+			return nil, io.EOF
+		case WS_PING:
+			if err := c.WriteMessage(WS_PONG, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case WS_PONG:
+			continue
+		}
+		msg = append(msg, payload...)
+		if len(msg) > wsMaxMessage {
+			return nil, fmt.Errorf("WebSocket message of %d bytes is too large", len(msg))
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// Sends a message as a single unmasked frame
+func (c *wsConn) WriteMessage(op byte, data []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	hdr := []byte{0x80 | op}
+	switch n := len(data); {
+	case n < 126:
+		hdr = append(hdr, byte(n))
+	case n < 1<<16:
+		hdr = append(hdr, 126, byte(n>>8), byte(n))
+	default:
+		hdr = append(hdr, 127)
+		hdr = binary.BigEndian.AppendUint64(hdr, uint64(n))
+	}
+
+	// Don't let a stalled browser hold up the panel
+	c.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.rw.Write(hdr); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(data); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+func (c *wsConn) Close() error {
+	return c.conn.Close()
+}